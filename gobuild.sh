@@ -37,7 +37,7 @@ print_help () {
 	echo "Usage: gobuild.sh [os] [program] <arch>"
 	echo ""
 	echo "   os: (mac, linux, win, all)"
-	echo " prog: (tint, parse)"
+	echo " prog: (tint, parse, tnslfmt)"
 	echo " arch: any supported go arch for the target os"
 	echo ""
 }
@@ -58,6 +58,7 @@ if $(is_os $1); then
 	if [[ -z $2 ]]; then
 		$1 tint
 		$1 parse
+		$1 tnslfmt
 	else
 		$1 $2
 	fi
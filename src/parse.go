@@ -22,34 +22,119 @@ import "texec"
 import "flag"
 import "os"
 
+func writeTokens(fd *os.File, format, file string, tokens []tparse.Token) error {
+	switch format {
+	case "json":
+		return tparse.WriteJSON(fd, tparse.JSONFile{Kind: "tokens", File: file, Tokens: tokens})
+	case "sexpr":
+		fd.WriteString(tparse.SExprTokens(tokens))
+	case "dot":
+		fd.WriteString(tparse.DotTokens(tokens, file))
+	default:
+		fd.WriteString(fmt.Sprint(tokens) + "\n")
+	}
+	return nil
+}
+
+func writeTree(fd *os.File, format, file string, tree tparse.Node) error {
+	switch format {
+	case "json":
+		return tparse.WriteJSON(fd, tparse.JSONFile{Kind: "tree", File: file, Tree: &tree})
+	case "sexpr":
+		fd.WriteString(tparse.SExprNode(tree))
+	case "dot":
+		fd.WriteString(tparse.DotNode(tree, file))
+	default:
+		fd.WriteString(fmt.Sprint(tree) + "\n")
+	}
+	return nil
+}
+
+func writeModule(fd *os.File, format, file string, root texec.TModule) error {
+	switch format {
+	case "json":
+		mod, err := texec.ModuleJSON(root)
+		if err != nil {
+			return err
+		}
+		return tparse.WriteJSON(fd, tparse.JSONFile{Kind: "module", File: file, Module: mod})
+	case "sexpr":
+		fd.WriteString(texec.SExprModule(root))
+	case "dot":
+		fd.WriteString(texec.DotModule(root))
+	default:
+		fd.WriteString(fmt.Sprint(root) + "\n")
+	}
+	return nil
+}
+
 func main() {
 	inputFile := flag.String("in", "", "The file to parse")
 	outputFile := flag.String("out", "out.tnt", "The file to store the node tree")
 	writeLevel := flag.Int("writelevel", 1, "The level of parsing to write to the file (for debugging)")
+	format := flag.String("format", "", "The format to write in (json, sexpr, or dot).  The default is Go's own printing of the data")
 
 	flag.Parse()
 
+	switch *format {
+	case "", "json", "sexpr", "dot":
+	default:
+		fmt.Printf("Unknown format %s (expected json, sexpr, or dot)\n", *format)
+		os.Exit(1)
+	}
+
 	fd, err := os.Create(*outputFile)
 
 	if err != nil {
 		fmt.Println(err.Error())
-		return
+		os.Exit(1)
 	}
 
-	
-	
+	var diags []tparse.Diagnostic
+
 	switch *writeLevel {
 	case 0:
-		tokens := tparse.TokenizeFile(*inputFile)
-		fd.WriteString(fmt.Sprint(tokens) + "\n")
+		var tokens []tparse.Token
+		tokens, diags, err = tparse.TokenizeFile(*inputFile)
+
+		if err == nil {
+			err = writeTokens(fd, *format, *inputFile, tokens)
+		}
 	case 1:
-		tokens := tparse.TokenizeFile(*inputFile)
-		tree := tparse.MakeTree(&tokens, *inputFile)
-		fd.WriteString(fmt.Sprint(tree) + "\n")
+		var in *os.File
+		var tree tparse.Node
+
+		in, err = os.Open(*inputFile)
+		if err == nil {
+			tree, diags, err = tparse.Parse(in, *inputFile)
+			in.Close()
+		}
+
+		// Write the tree even if it is only partially complete
+		if err == nil {
+			err = writeTree(fd, *format, *inputFile, tree)
+		}
 	case 2:
-		root := texec.BuildRoot(*inputFile)
-		fd.WriteString(fmt.Sprint(root) + "\n")
+		var root texec.TModule
+		root, diags, err = texec.BuildRoot(*inputFile)
+
+		if err == nil && !tparse.HasErrors(diags) {
+			err = writeModule(fd, *format, *inputFile, root)
+		}
 	}
 	
 	fd.Close()
+
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	for _, d := range diags {
+		fmt.Fprintln(os.Stderr, d.Error())
+	}
+
+	if tparse.HasErrors(diags) {
+		os.Exit(1)
+	}
 }
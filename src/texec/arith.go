@@ -0,0 +1,319 @@
+/*
+   Copyright 2020 Kyle Gunger
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package texec
+
+import "fmt"
+
+/**
+	arith.go - integer values and the operators on them.
+
+	Each integer keytype is stored as the go type of the same size (int8 as int8, uint16
+	as uint16, etc).  Integers are worked on as their raw bits in a uint64 and cut back
+	down to the size of their type, so they wrap around on overflow.
+*/
+
+// INTTYPES gives the size in bits of each integer keytype and if it is signed
+var INTTYPES = map[string]struct{
+	bits   int
+	signed bool
+}{
+	"int":    {64, true},
+	"int8":   {8, true},
+	"int16":  {16, true},
+	"int32":  {32, true},
+	"int64":  {64, true},
+	"uint":   {64, false},
+	"uint8":  {8, false},
+	"uint16": {16, false},
+	"uint32": {32, false},
+	"uint64": {64, false},
+	"char":   {8, false},
+}
+
+// Name of the integer keytype t is (skipping sk prefixes), if it is one
+func intType(t TType, sk int) (string, bool) {
+	if len(t.Pre) != sk || len(t.T.Path) != 0 || t.Post != "" {
+		return "", false
+	}
+
+	_, prs := INTTYPES[t.T.Name]
+	return t.T.Name, prs
+}
+
+// The bits of an integer value (sign extended to 64 bits) and if its go type is signed
+func intValue(d interface{}) (uint64, bool, bool) {
+	switch v := d.(type) {
+	case int:
+		return uint64(v), true, true
+	case int8:
+		return uint64(v), true, true
+	case int16:
+		return uint64(v), true, true
+	case int32:
+		return uint64(v), true, true
+	case int64:
+		return uint64(v), true, true
+	case uint:
+		return uint64(v), false, true
+	case uint8:
+		return uint64(v), false, true
+	case uint16:
+		return uint64(v), false, true
+	case uint32:
+		return uint64(v), false, true
+	case uint64:
+		return v, false, true
+	}
+	return 0, false, false
+}
+
+// Make a value of an integer keytype from its bits, cutting off any which do not fit
+func makeInt(name string, bits uint64) interface{} {
+	switch name {
+	case "int":
+		return int(bits)
+	case "int8":
+		return int8(bits)
+	case "int16":
+		return int16(bits)
+	case "int32":
+		return int32(bits)
+	case "int64":
+		return int64(bits)
+	case "uint":
+		return uint(bits)
+	case "uint8", "char":
+		return uint8(bits)
+	case "uint16":
+		return uint16(bits)
+	case "uint32":
+		return uint32(bits)
+	case "uint64":
+		return bits
+	}
+
+	errOut(fmt.Sprintf("[Internal] %s is not an integer type", name))
+	return nil
+}
+
+// Get the integer type and bits of an operand, or stop with an error
+func intOperand(op string, a *TVariable) (string, uint64) {
+	name, ok := intType(a.Type, 0)
+	bits, _, isInt := intValue(a.Data)
+	switch a.Data.(type) {
+	case float32, float64:
+		errOut(fmt.Sprintf("Operator %s can not be used on floats, but was given %v (type %v)", op, a.Data, a.Type))
+	}
+
+	if !ok || !isInt {
+		errOut(fmt.Sprintf("Operator %s only works on integers, but was given %v (type %v)", op, a.Data, a.Type))
+	}
+
+	// Make sure the bits match the size of the type
+	bits, _, _ = intValue(makeInt(name, bits))
+	return name, bits
+}
+
+// Eval a bitwise or shift operator.  The result has the type of the left side.
+func evalBitwise(op string, a, b *TVariable) *TVariable {
+	name, x := intOperand(op, a)
+	_, y := intOperand(op, b)
+
+	var out uint64
+	switch op {
+	case "&":
+		out = x & y
+	case "|":
+		out = x | y
+	case "^":
+		out = x ^ y
+	case "!&":
+		out = ^(x & y)
+	case "!|":
+		out = ^(x | y)
+	case "!^":
+		out = ^(x ^ y)
+	case "<<", ">>":
+		if _, signed, _ := intValue(b.Data); signed && int64(y) < 0 {
+			errOut(fmt.Sprintf("Shift by a negative amount (%v)", b.Data))
+		}
+
+		if op == "<<" {
+			out = x << y
+		} else if INTTYPES[name].signed {
+			out = uint64(int64(x) >> y)
+		} else {
+			out = x >> y
+		}
+	}
+
+	return &TVariable{a.Type, makeInt(name, out)}
+}
+
+// Eval the bitwise complement (!a) of an integer
+func evalComplement(a *TVariable) *TVariable {
+	name, x := intOperand("!", a)
+	return &TVariable{a.Type, makeInt(name, ^x)}
+}
+
+// Is the value an integer (both its type and the data holding it)
+func isIntValue(a *TVariable) bool {
+	_, ok := intType(a.Type, 0)
+	_, _, isInt := intValue(a.Data)
+	return ok && isInt
+}
+
+// Compare two integers, which may have different sizes and signs.  Gives -1, 0, or 1.
+func compareInt(x uint64, xs bool, y uint64, ys bool) int {
+	xn, yn := xs && int64(x) < 0, ys && int64(y) < 0
+
+	switch {
+	case xn && !yn:
+		return -1
+	case yn && !xn:
+		return 1
+	case xn && yn:
+		if int64(x) < int64(y) {
+			return -1
+		} else if int64(x) > int64(y) {
+			return 1
+		}
+		return 0
+	}
+
+	if x < y {
+		return -1
+	} else if x > y {
+		return 1
+	}
+	return 0
+}
+
+// Eval an arithmetic or comparison operator on two integers.  For arithmetic the right
+// side is converted to the type of the left, which is also the type of the result.
+// Returns false if the operator is not one for integers.
+func evalInt(op string, a, b *TVariable) (*TVariable, bool) {
+	name, x := intOperand(op, a)
+	bname, y := intOperand(op, b)
+	signed := INTTYPES[name].signed
+
+	cmp := func(ok bool) (*TVariable, bool) {
+		return &TVariable{tBool, ok}, true
+	}
+
+	switch op {
+	case "==":
+		return cmp(compareInt(x, signed, y, INTTYPES[bname].signed) == 0)
+	case "!==":
+		return cmp(compareInt(x, signed, y, INTTYPES[bname].signed) != 0)
+	case ">":
+		return cmp(compareInt(x, signed, y, INTTYPES[bname].signed) > 0)
+	case "<":
+		return cmp(compareInt(x, signed, y, INTTYPES[bname].signed) < 0)
+	case ">==", "!<":
+		return cmp(compareInt(x, signed, y, INTTYPES[bname].signed) >= 0)
+	case "<==", "!>":
+		return cmp(compareInt(x, signed, y, INTTYPES[bname].signed) <= 0)
+	}
+
+	y, _, _ = intValue(makeInt(name, y))
+
+	var out uint64
+	switch op {
+	case "+":
+		out = x + y
+	case "-":
+		out = x - y
+	case "*":
+		out = x * y
+	case "/", "%":
+		if y == 0 {
+			errOut(fmt.Sprintf("Division by zero (%v %s %v)", a.Data, op, b.Data))
+		}
+
+		// Division truncates toward zero
+		if signed && op == "/" {
+			out = uint64(int64(x) / int64(y))
+		} else if signed {
+			out = uint64(int64(x) % int64(y))
+		} else if op == "/" {
+			out = x / y
+		} else {
+			out = x % y
+		}
+	default:
+		return nil, false
+	}
+
+	return &TVariable{a.Type, makeInt(name, out)}, true
+}
+
+// FLOATTYPES lists the floating point keytypes.  float is the same size as float64.
+var FLOATTYPES = map[string]bool{
+	"float":   true,
+	"float32": true,
+	"float64": true,
+}
+
+// Name of the float keytype t is (skipping sk prefixes), if it is one
+func floatType(t TType, sk int) (string, bool) {
+	if len(t.Pre) != sk || len(t.T.Path) != 0 || t.Post != "" {
+		return "", false
+	}
+
+	return t.T.Name, FLOATTYPES[t.T.Name]
+}
+
+// The type of a float operation.  float32 is only used if there is no bigger float in it.
+func floatResult(a, b TType) TType {
+	fa, aok := floatType(a, 0)
+	fb, bok := floatType(b, 0)
+
+	switch {
+	case aok && fa != "float32":
+		return a
+	case bok && fb != "float32":
+		return b
+	case aok:
+		return a
+	case bok:
+		return b
+	}
+
+	return tFloat
+}
+
+// Eval the negative of a number, keeping its type
+func evalNegate(a *TVariable) *TVariable {
+	if isIntValue(a) {
+		name, x := intOperand("-", a)
+		return &TVariable{a.Type, makeInt(name, -x)}
+	}
+
+	t := a.Type
+	if _, ok := floatType(t, 0); !ok {
+		t = tFloat
+	}
+
+	f := convertValPS(tFloat, 0, a.Data).(float64)
+	return &TVariable{t, convertValPS(t, 0, -f)}
+}
+
+// The value of a variable after ++ (op "+") or -- (op "-").  ref holds a pointer to the variable's data.
+func stepRef(ref *TVariable, op string) *TVariable {
+	return evalBinary(op, &TVariable{ref.Type, *(ref.Data.(*interface{}))}, &TVariable{tInt, 1})
+}
@@ -0,0 +1,190 @@
+/*
+   Copyright 2020 Kyle Gunger
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package texec
+
+import (
+	"fmt"
+	"math"
+	"tparse"
+)
+
+/**
+	cast.go - explicit conversions written as value[type].
+
+	Numbers (integers of any size, chars, and floats) can be cast to each other and to
+	bool.  Integers are cut down to the size of the new type the same way arithmetic
+	wraps around, floats are truncated toward zero and must fit in the integer type.  A
+	bool is 1 or 0, and a number is true if it is not zero.
+
+	Pointers can be cast to other pointer types when the values they point to are stored
+	the same way: integers of the same size and sign, floats of the same size, arrays or
+	pointers of those, or a struct and a struct it extends (the members of the new type
+	must come first in the old one).  The new pointer still points to the same data.
+
+	Casts which can never work are reported before the program runs when the type of
+	the value is known (see checkCasts), and when the cast is run otherwise.
+*/
+
+// Is the node a [type] cast
+func isCast(n tparse.Node) bool {
+	return n.Data.Type == 10 && n.Data.Data == "cast"
+}
+
+// Does a float fit in an integer keytype once truncated
+func floatFits(f float64, name string) bool {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return false
+	}
+
+	f = math.Trunc(f)
+	it := INTTYPES[name]
+	if it.signed {
+		return f >= -math.Ldexp(1, it.bits - 1) && f < math.Ldexp(1, it.bits - 1)
+	}
+	return f >= 0 && f < math.Ldexp(1, it.bits)
+}
+
+// Is the type a number or bool
+func isScalar(t TType) bool {
+	_, isInt := intType(t, 0)
+	_, isFloat := floatType(t, 0)
+	return isInt || isFloat || equateType(t, tBool)
+}
+
+// Can memory holding a value of type a be used as a value of type b.  Structs are only
+// compared if structs is set, as their members are not known until the program is loaded.
+func sameLayout(a, b TType, structs bool) bool {
+	ia, aInt := intType(a, 0)
+	ib, bInt := intType(b, 0)
+	fa, aFloat := floatType(a, 0)
+	fb, bFloat := floatType(b, 0)
+
+	switch {
+	case equateType(a, b):
+		return true
+	case aInt && bInt:
+		return INTTYPES[ia] == INTTYPES[ib]
+	case aFloat && bFloat:
+		return (fa == "float32") == (fb == "float32")
+	case isPointer(a, 0) && isPointer(b, 0), isArray(a, 0) && isArray(b, 0):
+		return sameLayout(stripType(a, 1), stripType(b, 1), structs)
+	case isScalar(a) || isScalar(b) || isPointer(a, 0) || isPointer(b, 0) || isArray(a, 0) || isArray(b, 0):
+		return false
+	}
+	return !structs || sameMembers(a, b)
+}
+
+// Do the members of struct b come first in struct a, with the same names and types
+func sameMembers(a, b TType) bool {
+	sa, _ := searchDef(a.T)
+	sb, _ := searchDef(b.T)
+	if sa == nil || sb == nil || !equateType(sa.Type, tStruct) || !equateType(sb.Type, tStruct) {
+		return false
+	}
+
+	ma, _ := structFields(a.T)
+	mb, _ := structFields(b.T)
+	if len(mb) > len(ma) {
+		return false
+	}
+
+	for i := range mb {
+		if ma[i].Data != mb[i].Data || !equateType(ma[i].Type, mb[i].Type) {
+			return false
+		}
+	}
+	return true
+}
+
+// The problem with casting a value of type a to type b, judged by the types alone ("" if
+// there is none).  structs is passed on to sameLayout.
+func castProblem(a, b TType, structs bool) string {
+	switch {
+	case equateType(a, b), isScalar(a) && isScalar(b):
+		return ""
+	case isPointer(a, 0) && isPointer(b, 0):
+		if sameLayout(stripType(a, 1), stripType(b, 1), structs) {
+			return ""
+		}
+		return fmt.Sprintf("Can not cast %s to %s, the values they point to are not stored the same way", typeString(a), typeString(b))
+	}
+	return fmt.Sprintf("Can not cast a value of type %s to %s", typeString(a), typeString(b))
+}
+
+// Cast a value to the type in a cast node
+func castValue(a *TVariable, c tparse.Node) *TVariable {
+	if len(c.Sub) != 1 {
+		errOutNode("A cast must have exactly one type", c)
+	}
+	to := getType(c.Sub[0])
+
+	if a == nil {
+		errOutNode(fmt.Sprintf("Can not cast a value which does not exist to %s", typeString(to)), c)
+	} else if msg := castProblem(a.Type, to, true); msg != "" {
+		errOutNode(msg, c)
+	}
+
+	toInt, isToInt := intType(to, 0)
+	_, isToFloat := floatType(to, 0)
+	toBool := equateType(to, tBool)
+
+	fromInt := isIntValue(a)
+	_, fromFloat := floatType(a.Type, 0)
+	fromBool := equateType(a.Type, tBool)
+
+	switch {
+	case equateType(a.Type, to):
+		return &TVariable{to, a.Data}
+	case isPointer(a.Type, 0) && isPointer(to, 0):
+		return &TVariable{to, a.Data}
+	case fromBool && (isToInt || isToFloat):
+		n := 0
+		if a.Data.(bool) {
+			n = 1
+		}
+		return castValue(&TVariable{tInt, n}, c)
+	case toBool && fromInt:
+		bits, _, _ := intValue(a.Data)
+		return &TVariable{to, bits != 0}
+	case toBool && fromFloat:
+		return &TVariable{to, convertValPS(tFloat, 0, a.Data).(float64) != 0}
+	case isToInt && fromInt:
+		bits, _, _ := intValue(a.Data)
+		return &TVariable{to, makeInt(toInt, bits)}
+	case isToInt && fromFloat:
+		f := convertValPS(tFloat, 0, a.Data).(float64)
+		if !floatFits(f, toInt) {
+			errOutNode(fmt.Sprintf("Can not cast %v to %s, it does not fit", f, typeString(to)), c)
+		}
+		if INTTYPES[toInt].signed {
+			return &TVariable{to, makeInt(toInt, uint64(int64(f)))}
+		}
+		return &TVariable{to, makeInt(toInt, uint64(f))}
+	case isToFloat && fromInt:
+		bits, signed, _ := intValue(a.Data)
+		f := float64(bits)
+		if signed {
+			f = float64(int64(bits))
+		}
+		return &TVariable{to, convertValPS(to, 0, f)}
+	case isToFloat && fromFloat:
+		return &TVariable{to, convertValPS(to, 0, convertValPS(tFloat, 0, a.Data))}
+	}
+
+	errOutNode(fmt.Sprintf("Can not cast a value of type %s to %s", typeString(a.Type), typeString(to)), c)
+	return nil
+}
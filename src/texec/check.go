@@ -0,0 +1,416 @@
+/*
+   Copyright 2020 Kyle Gunger
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package texec
+
+import (
+	"fmt"
+	"tparse"
+)
+
+/**
+	check.go - problems in a file which can be found before it is run, such as duplicate
+	case values, gotos without a label, and casts which can never work.  These are returned from BuildRoot along with
+	the syntax errors.
+*/
+
+// The value nodes of a case block (case (1, 2))
+func caseValues(c tparse.Node) []tparse.Node {
+	out := []tparse.Node{}
+	for i := 0; i < len(c.Sub[0].Sub); i++ {
+		if c.Sub[0].Sub[i].Data.Data != "()" {
+			continue
+		}
+		for _, v := range c.Sub[0].Sub[i].Sub {
+			if v.Data.Data == "value" {
+				out = append(out, v.Sub[0])
+			}
+		}
+	}
+	return out
+}
+
+// A key for a case value which is a literal, so equal constants have equal keys.
+// Returns false if the value is not a constant.
+func constKey(v tparse.Node) (string, bool) {
+	neg := ""
+	if v.Data.Data == "-" && v.Data.Type == tparse.AUGMENT && len(v.Sub) == 1 {
+		neg = "-"
+		v = v.Sub[0]
+	}
+
+	if v.Data.Type != tparse.LITERAL || len(v.Sub) > 0 {
+		return "", false
+	}
+
+	switch v.Data.Kind {
+	case tparse.INTLIT:
+		return fmt.Sprintf("%s%d", neg, getIntLiteral(v)), true
+	case tparse.CHARLIT:
+		return fmt.Sprintf("%s%d", neg, getCharLiteral(v)), true
+	case tparse.FLOATLIT:
+		return fmt.Sprintf("%s%v", neg, getFloatLiteral(v)), true
+	case tparse.STRLIT, tparse.BOOLLIT:
+		if neg == "" {
+			return v.Data.Data, true
+		}
+	}
+	return "", false
+}
+
+// Check the cases of a match block.  Reports case values which are the same as one
+// before them, and cases (or default blocks) which can never run.
+func checkMatch(m tparse.Node, file string) []tparse.Diagnostic {
+	out := []tparse.Diagnostic{}
+	seen := map[string]bool{}
+	def := false
+
+	// Blocks which can never run are warnings, duplicate values are errors
+	diag := func(msg string, at tparse.Token, warn bool) {
+		out = append(out, tparse.Diagnostic{Message: msg, File: file, Line: at.Line, Col: at.Char + 1, Token: at, Warning: warn})
+	}
+
+	for i := 1; i < len(m.Sub); i++ {
+		c := m.Sub[i]
+		if isBlock(c, "default") {
+			if def {
+				diag("Match already has a default block, this one can never run", c.Sub[0].Sub[0].Data, true)
+			}
+			def = true
+			continue
+		} else if !isBlock(c, "case") {
+			continue
+		}
+
+		vals := caseValues(c)
+		live := false
+		for _, v := range vals {
+			k, ok := constKey(v)
+			if !ok {
+				live = true
+			} else if seen[k] {
+				txt := v.Data.Text()
+				if len(v.Sub) > 0 {
+					txt += v.Sub[0].Data.Text()
+				}
+				diag(fmt.Sprintf("Duplicate case value %s", txt), v.Data, false)
+			} else {
+				seen[k] = true
+				live = true
+			}
+		}
+
+		if !live {
+			diag("Case can never run", c.Sub[0].Sub[0].Data, true)
+		}
+	}
+
+	return out
+}
+
+// Is the node a control flow block (if, loop, match, etc.) rather than a function or module
+func isControlFlow(n tparse.Node) bool {
+	for _, kw := range []string{"if", "else", "loop", "match", "case", "default"} {
+		if isBlock(n, kw) {
+			return true
+		}
+	}
+	return false
+}
+
+// Is the node a label or goto statement
+func isJump(n tparse.Node, kw string) bool {
+	return n.Data.Type == tparse.KEYWORD && n.Data.Data == kw && len(n.Sub) > 0
+}
+
+// Check the labels and gotos in a function.  Labels must be unique in the function, and a goto
+// may only jump to a label in its own block or one of the blocks around it.  all holds every
+// label in the file, so jumps to another function can be told apart from missing labels.
+func checkLabels(fn tparse.Node, file string, all map[string]bool) []tparse.Diagnostic {
+	out := []tparse.Diagnostic{}
+	labels := map[string]*tparse.Node{}
+
+	type jump struct {
+		at     tparse.Token
+		blocks []*tparse.Node
+	}
+	gotos := []jump{}
+
+	var walk func(b *tparse.Node, path []*tparse.Node)
+	walk = func(b *tparse.Node, path []*tparse.Node) {
+		path = append(append([]*tparse.Node{}, path...), b)
+		for i := range b.Sub {
+			s := b.Sub[i]
+			if isJump(s, "label") {
+				name := s.Sub[0].Data
+				if _, prs := labels[name.Data]; prs {
+					out = append(out, tparse.Diagnostic{Message: fmt.Sprintf("Duplicate label %s", name.Data), File: file, Line: name.Line, Col: name.Char + 1, Token: name})
+				} else {
+					labels[name.Data] = b
+				}
+			} else if isJump(s, "goto") {
+				gotos = append(gotos, jump{s.Sub[0].Data, path})
+			} else if isControlFlow(s) {
+				walk(&(b.Sub[i]), path)
+			}
+		}
+	}
+	walk(&fn, nil)
+
+	for _, g := range gotos {
+		b, prs := labels[g.at.Data]
+		msg := ""
+		if !prs && all[g.at.Data] {
+			msg = fmt.Sprintf("Can not goto %s, the label is in another function", g.at.Data)
+		} else if !prs {
+			msg = fmt.Sprintf("Missing label %s for goto", g.at.Data)
+		} else {
+			msg = fmt.Sprintf("Can not goto %s, the label is inside a block the goto is not in", g.at.Data)
+			for _, p := range g.blocks {
+				if p == b {
+					msg = ""
+				}
+			}
+		}
+
+		if msg != "" {
+			out = append(out, tparse.Diagnostic{Message: msg, File: file, Line: g.at.Line, Col: g.at.Char + 1, Token: g.at})
+		}
+	}
+
+	return out
+}
+
+// Names made by a define statement
+func defineNames(d tparse.Node) []tparse.Token {
+	out := []tparse.Token{}
+	for _, v := range d.Sub[1].Sub {
+		if v.Data.Type == tparse.DEFWORD {
+			out = append(out, v.Data)
+		} else if len(v.Sub) > 0 {
+			out = append(out, v.Sub[0].Data)
+		}
+	}
+	return out
+}
+
+// Warn about variables which hide one from a block around them in the same function
+func checkShadow(fn tparse.Node, file string) []tparse.Diagnostic {
+	out := []tparse.Diagnostic{}
+
+	var walk func(b tparse.Node, outer []map[string]bool)
+	walk = func(b tparse.Node, outer []map[string]bool) {
+		here := map[string]bool{}
+		define := func(at tparse.Token) {
+			for _, o := range outer {
+				if o[at.Data] {
+					out = append(out, tparse.Diagnostic{Message: fmt.Sprintf("%s shadows a variable from an outer block", at.Data), File: file, Line: at.Line, Col: at.Char + 1, Token: at, Warning: true})
+					break
+				}
+			}
+			here[at.Data] = true
+		}
+
+		// Function parameters and definitions in a control flow's () belong to the block
+		if len(b.Sub) > 0 && b.Sub[0].Data.Data == "bdef" {
+			for _, s := range b.Sub[0].Sub {
+				if s.Data.Data != "()" {
+					continue
+				}
+				for _, p := range s.Sub {
+					if p.Data.Data == "define" {
+						for _, at := range defineNames(p) {
+							define(at)
+						}
+					} else if !isControlFlow(b) && p.Data.Type == tparse.DEFWORD {
+						define(p.Data)
+					}
+				}
+			}
+		}
+
+		inner := append(append([]map[string]bool{}, outer...), here)
+		for _, s := range b.Sub {
+			if s.Data.Data == "define" && s.Data.Type == 10 {
+				for _, at := range defineNames(s) {
+					define(at)
+				}
+			} else if isControlFlow(s) {
+				walk(s, inner)
+			}
+		}
+	}
+	walk(fn, nil)
+
+	return out
+}
+
+// The type in a type node, if it can be known without running anything (array lengths
+// other than {} may need to be evaluated).  Const is removed, the value has the same type.
+func checkType(n tparse.Node) (TType, bool) {
+	for _, s := range n.Sub {
+		if s.Data.Data == "{}" && len(s.Sub) > 0 {
+			return TType{}, false
+		}
+	}
+
+	t, _ := splitConst(getType(n))
+	for _, p := range t.Pre {
+		if p != "~" && p != "{}" {
+			return t, false
+		}
+	}
+	return t, true
+}
+
+// Record the type of a variable.  A name given more than one type is set to nil.
+func addType(types map[string]*TType, name string, t TType, ok bool) {
+	if old, prs := types[name]; !ok || (prs && (old == nil || !equateType(*old, t))) {
+		types[name] = nil
+	} else {
+		types[name] = &t
+	}
+}
+
+// Record the types of the variables in a define statement
+func defineTypes(d tparse.Node, types map[string]*TType) {
+	t, ok := checkType(d.Sub[0])
+	for _, at := range defineNames(d) {
+		addType(types, at.Data, t, ok)
+	}
+}
+
+// Report casts which can never work, where the type of the value is known from a literal
+// or from the definition of a variable.  Names defined with more than one type, and casts
+// of any other value, are left to be checked when the cast is run.
+func checkCasts(fn tparse.Node, file string, globals map[string]*TType) []tparse.Diagnostic {
+	out := []tparse.Diagnostic{}
+	types := map[string]*TType{}
+	for k, v := range globals {
+		types[k] = v
+	}
+
+	// Parameters are a type followed by the names which have it
+	if len(fn.Sub) > 0 && fn.Sub[0].Data.Data == "bdef" && !isControlFlow(fn) {
+		for _, s := range fn.Sub[0].Sub {
+			if s.Data.Data != "()" {
+				continue
+			}
+
+			t, ok := TType{}, false
+			for _, p := range s.Sub {
+				if p.Data.Data == "type" && p.Data.Type == 10 {
+					t, ok = checkType(p)
+				} else if p.Data.Type == tparse.DEFWORD {
+					addType(types, p.Data.Data, t, ok)
+				}
+			}
+		}
+	}
+
+	// Functions inside this block are checked on their own
+	inspect := func(f func(n *tparse.Node)) {
+		tparse.Inspect(&fn, func(n *tparse.Node) bool {
+			if n == nil {
+				return false
+			} else if n != &fn && n.Data.Data == "block" && n.Data.Type == 10 && !isControlFlow(*n) {
+				return false
+			}
+			f(n)
+			return true
+		})
+	}
+
+	inspect(func(n *tparse.Node) {
+		if n.Data.Data == "define" && n.Data.Type == 10 {
+			defineTypes(*n, types)
+		}
+	})
+
+	// The right side of a . is a member, not a variable
+	members := map[*tparse.Node]bool{}
+	inspect(func(n *tparse.Node) {
+		if n.Data.Data == "." && n.Data.Type == tparse.AUGMENT && len(n.Sub) == 2 {
+			members[&(n.Sub[1])] = true
+		}
+
+		if len(n.Sub) == 0 || !isCast(n.Sub[0]) || len(n.Sub[0].Sub) != 1 {
+			return
+		}
+
+		var from *TType
+		if n.Data.Type == tparse.LITERAL {
+			lt := getLiteralType(*n)
+			from = &lt
+		} else if n.Data.Type == tparse.DEFWORD && !members[n] {
+			from = types[n.Data.Data]
+		}
+
+		to, ok := checkType(n.Sub[0].Sub[0])
+		if from == nil || !ok {
+			return
+		}
+
+		msg := castProblem(*from, to, false)
+		if toInt, isToInt := intType(to, 0); msg == "" && isToInt && n.Data.Kind == tparse.FLOATLIT {
+			if f := getFloatLiteral(*n); !floatFits(f, toInt) {
+				msg = fmt.Sprintf("Can not cast %v to %s, it does not fit", f, typeString(to))
+			}
+		}
+
+		if msg != "" {
+			out = append(out, tparse.Diagnostic{Message: msg, File: file, Line: n.Data.Line, Col: n.Data.Char + 1, Token: n.Data})
+		}
+	})
+
+	return out
+}
+
+// Run the checks on a parsed file
+func checkFile(root *tparse.Node, file string) []tparse.Diagnostic {
+	out := []tparse.Diagnostic{}
+
+	all := map[string]bool{}
+	globals := map[string]*TType{}
+	for _, n := range root.Sub {
+		if n.Data.Data == "define" && n.Data.Type == 10 {
+			defineTypes(n, globals)
+		}
+	}
+
+	tparse.Inspect(root, func(n *tparse.Node) bool {
+		if n != nil && isJump(*n, "label") {
+			all[n.Sub[0].Data.Data] = true
+		}
+		return true
+	})
+
+	tparse.Inspect(root, func(n *tparse.Node) bool {
+		if n == nil || n.Data.Data != "block" || n.Data.Type != 10 {
+			return true
+		}
+
+		if isBlock(*n, "match") {
+			out = append(out, checkMatch(*n, file)...)
+		} else if !isControlFlow(*n) {
+			out = append(out, checkLabels(*n, file, all)...)
+			out = append(out, checkShadow(*n, file)...)
+			out = append(out, checkCasts(*n, file, globals)...)
+		}
+		return true
+	})
+	return out
+}
@@ -19,6 +19,7 @@ package texec
 import (
 	"tparse"
 	"fmt"
+	"os"
 	"strconv"
 	"strings"
 )
@@ -52,31 +53,47 @@ var (
 
 // Error helper
 
+// Errors are written to stderr, then the interpreter stops with this panic
+const evalPanic = ">>> PANIC FROM EVAL <<<"
+
+// IsEvalError tells if a value recovered from a panic is from an error in the program,
+// which has already been written out
+func IsEvalError(r interface{}) bool {
+	return r == evalPanic
+}
+
 func errOut(msg string) {
-	fmt.Println("==== BEGIN ERROR ====")
-	fmt.Println(msg)
-	fmt.Println(cart)
-	fmt.Println("==== END ERROR ====")
-	panic(">>> PANIC FROM EVAL <<<")
+	fmt.Fprintln(os.Stderr, "==== BEGIN ERROR ====")
+	fmt.Fprintln(os.Stderr, msg)
+	fmt.Fprintln(os.Stderr, cart)
+	fmt.Fprintln(os.Stderr, "==== END ERROR ====")
+	panic(evalPanic)
 }
 
-func errOutCTX(msg string, ctx *VarMap) {
-	fmt.Println("==== BEGIN ERROR ====")
-	fmt.Println(msg)
-	fmt.Println(cart)
-	fmt.Println(*ctx)
-	fmt.Println("====  END  ERROR ====")
-	panic(">>> PANIC FROM EVAL <<<")
+func errOutCTX(msg string, ctx *Scope) {
+	fmt.Fprintln(os.Stderr, "==== BEGIN ERROR ====")
+	fmt.Fprintln(os.Stderr, msg)
+	fmt.Fprintln(os.Stderr, cart)
+	for s := ctx; s != nil; s = s.Parent {
+		fmt.Fprintln(os.Stderr, s.Vars)
+	}
+	fmt.Fprintln(os.Stderr, "====  END  ERROR ====")
+	panic(evalPanic)
 }
 
 func errOutNode(msg string, n tparse.Node) {
-	fmt.Println("==== BEGIN ERROR ====")
-	fmt.Println(msg)
-	fmt.Println(cart)
-	fmt.Printf("Line: %v Char: %v\n", n.Data.Line, n.Data.Char)
-	fmt.Printf("Data: %s\n", n.Data.Data)
-	fmt.Println("====  END  ERROR ====")
-	panic(">>> PANIC FROM EVAL <<<")
+	fmt.Fprintln(os.Stderr, "==== BEGIN ERROR ====")
+	fmt.Fprintln(os.Stderr, msg)
+	fmt.Fprintln(os.Stderr, cart)
+	start, end := n.Start, n.End
+	if start.Line == 0 {
+		start, end = n.Data.Start(), n.Data.End
+	}
+	fmt.Fprintf(os.Stderr, "From: Line %v Char %v (byte %v)\n", start.Line, start.Char, start.Offset)
+	fmt.Fprintf(os.Stderr, "To:   Line %v Char %v (byte %v)\n", end.Line, end.Char, end.Offset)
+	fmt.Fprintf(os.Stderr, "Data: %s\n", n.Data.Data)
+	fmt.Fprintln(os.Stderr, "====  END  ERROR ====")
+	panic(evalPanic)
 }
 
 // Names of artifacts, finding artifacts
@@ -103,7 +120,7 @@ func getBlockName(block tparse.Node) []string {
 	for i := 0; i < len(block.Sub[0].Sub); i++ {
 		if block.Sub[0].Sub[i].Data.Type == tparse.DEFWORD {
 			out = append(out, block.Sub[0].Sub[i].Data.Data)
-		} else if block.Sub[0].Sub[i].Data.Data == "method" {
+		} else if block.Sub[0].Sub[i].Data.Data == "method" || block.Sub[0].Sub[i].Data.Data == "interface" {
 			out = append(out, block.Sub[0].Sub[i].Sub[0].Data.Data)
 		} else if block.Sub[0].Sub[i].Data.Type == tparse.KEYWORD {
 			switch block.Sub[0].Sub[i].Data.Data {
@@ -317,6 +334,11 @@ func getType(t tparse.Node) TType {
 			break
 		} else {
 			out.Pre = append(out.Pre, t.Sub[i].Data.Data)
+			if t.Sub[i].Data.Data == "{}" && len(t.Sub[i].Sub) > 0 && len(t.Sub[i].Sub[0].Sub) > 0 {
+				out.Len = append(out.Len, arrayLength(t.Sub[i].Sub[0].Sub[0]))
+			} else {
+				out.Len = append(out.Len, 0)
+			}
 		}
 	}
 
@@ -351,11 +373,42 @@ func getType(t tparse.Node) TType {
 }
 
 func stripType(t TType, s int) TType {
-	return TType{t.Pre[s:], t.T, t.Post}
+	if len(t.Len) > s {
+		return TType{t.Pre[s:], t.T, t.Post, t.Len[s:]}
+	}
+	return TType{t.Pre[s:], t.T, t.Post, nil}
 }
 
 func prependType(t TType, p string) TType {
-	return TType{append(t.Pre, p), t.T, t.Post}
+	return TType{append(t.Pre, p), t.T, t.Post, t.Len}
+}
+
+// The length of an array type given in its {}.  Must be a literal or a value which
+// can be found before main runs.
+func arrayLength(n tparse.Node) int {
+	l := 0
+	if isLiteral(n) && n.Data.Kind == tparse.INTLIT {
+		l = getIntLiteral(n)
+	} else {
+		v := evalValue(n, newScope(nil))
+		if v == nil || !isIntValue(v) {
+			errOutNode("The length of an array type must be an integer", n)
+		}
+		l = convertVal(v, tInt).Data.(int)
+	}
+
+	if l < 0 {
+		errOutNode(fmt.Sprintf("The length of an array type can not be negative (%d)", l), n)
+	}
+	return l
+}
+
+// The fixed length of the array skipping sk prefixes, or 0 if it does not have one
+func arrayLen(t TType, sk int) int {
+	if len(t.Len) > sk {
+		return t.Len[sk]
+	}
+	return 0
 }
 
 // Value generation
@@ -388,7 +441,8 @@ func getCharLiteral(v tparse.Node) byte {
 }
 
 func getIntLiteral(v tparse.Node) int {
-	i, err := strconv.ParseInt(v.Data.Data, 0, 64)
+	dig, base := tparse.NumberDigits(v.Data.Data)
+	i, err := strconv.ParseUint(dig, base, 64)
 
 	if err != nil {
 		errOut(fmt.Sprintf("Failed to parse integer literal. %v", v.Data))
@@ -398,7 +452,12 @@ func getIntLiteral(v tparse.Node) int {
 }
 
 func getFloatLiteral(v tparse.Node) float64 {
-	i, err := strconv.ParseFloat(v.Data.Data, 64)
+	if v.Data.Kind == tparse.INTLIT {
+		return float64(getIntLiteral(v))
+	}
+
+	dig, _ := tparse.NumberDigits(v.Data.Data)
+	i, err := strconv.ParseFloat(dig, 64)
 
 	if err != nil {
 		errOut(fmt.Sprintf("Failed to parse float literal. %v", v.Data))
@@ -407,21 +466,42 @@ func getFloatLiteral(v tparse.Node) float64 {
 	return float64(i)
 }
 
+// Number literals hold the go type matching their tnsl type where there is one
+func getNumberLiteral(v tparse.Node, t TType) interface{} {
+	if name, ok := floatType(t, 0); ok || v.Data.Kind == tparse.FLOATLIT {
+		if name == "float32" {
+			return float32(getFloatLiteral(v))
+		}
+		return getFloatLiteral(v)
+	}
+
+	i := getIntLiteral(v)
+	if name, ok := intType(t, 0); ok {
+		return makeInt(name, uint64(i))
+	}
+
+	return i
+}
+
 func getLiteralComposite(v tparse.Node) []interface{} {
 	out := []interface{}{}
 
 	for i := 0; i < len(v.Sub); i++ {
-		if v.Sub[i].Data.Data[0] == '"' {
+		if v.Sub[i].Data.Data == "comp" {
+			out = append(out, getLiteralComposite(v.Sub[i]))
+			continue
+		}
+
+		switch v.Sub[i].Data.Kind {
+		case tparse.STRLIT:
 			out = append(out, getStringLiteral(v.Sub[i]))
-		} else if v.Sub[i].Data.Data[0] == '\'' {
+		case tparse.CHARLIT:
 			out = append(out, getCharLiteral(v.Sub[i]))
-		} else if v.Sub[i].Data.Data == "comp" {
-			out = append(out, getLiteralComposite(v.Sub[i]))
-		} else if v.Sub[i].Data.Data[0] == '0' {
-			out = append(out, getIntLiteral(v.Sub[i]))
-		} else if v.Sub[i].Data.Data == "true" || v.Sub[i].Data.Data == "false" {
+		case tparse.BOOLLIT:
 			out = append(out, getBoolLiteral(v.Sub[i]))
-		} else {
+		case tparse.INTLIT:
+			out = append(out, getIntLiteral(v.Sub[i]))
+		default:
 			out = append(out, getFloatLiteral(v.Sub[i]))
 		}
 	}
@@ -434,7 +514,9 @@ func getBoolLiteral(v tparse.Node) bool {
 }
 
 func getLiteral(v tparse.Node, t TType) interface{} {
-	if equateType(t, tFloat) {
+	if v.Data.Kind == tparse.INTLIT || v.Data.Kind == tparse.FLOATLIT {
+		return getNumberLiteral(v, t)
+	} else if equateType(t, tFloat) {
 		return getFloatLiteral(v)
 	} else if equateType(t, tByte) {
 		return getCharLiteral(v)
@@ -450,30 +532,72 @@ func getLiteral(v tparse.Node, t TType) interface{} {
 }
 
 func getLiteralType(v tparse.Node) TType {
-	if v.Data.Data[0] == '"' {
+	if v.Data.Data == "comp" {
+		return tStruct
+	}
+
+	switch v.Data.Kind {
+	case tparse.STRLIT:
 		return tString
-	} else if v.Data.Data[0] == '\'' {
+	case tparse.CHARLIT:
 		return tByte
-	} else if v.Data.Data == "comp" {
-		return tStruct
-	} else if v.Data.Data == "true" || v.Data.Data == "false" {
+	case tparse.BOOLLIT:
 		return tBool
-	} else if len(v.Data.Data) > 2 && v.Data.Data[0] == '0' && v.Data.Data[1] != '.' {
-		return tInt
-	} else {
-		return tFloat
+	case tparse.INTLIT, tparse.FLOATLIT:
+		_, suf := tparse.SplitNumber(v.Data.Data)
+		if suf != "" {
+			return TType{Pre: []string{}, T: TArtifact{Path: []string{}, Name: tparse.LITSUFFIX[suf]}, Post: ""}
+		} else if v.Data.Kind == tparse.INTLIT {
+			return tInt
+		}
+	}
+
+	return tFloat
+}
+
+// The parent of a struct type, if it extends one
+func structParent(st TArtifact) (TType, bool) {
+	sv, _ := searchDef(st)
+	if sv == nil || !equateType(sv.Type, tStruct) {
+		return tNull, false
+	}
+
+	vars := sv.Data.([]TVariable)
+	if len(vars) > 0 && vars[0].Data.(string) == "super" {
+		return vars[0].Type, true
 	}
+	return tNull, false
+}
+
+// The members of a struct type, including those from the structs it extends (which come first).
+// Also gives the absolute path to the struct.
+func structFields(st TArtifact) ([]TVariable, TArtifact) {
+	sv, st := searchDef(st)
+	if sv == nil {
+		errOut(fmt.Sprintf("Could not find struct %v", st))
+	}
+
+	vars := sv.Data.([]TVariable)
+	if len(vars) == 0 || vars[0].Data.(string) != "super" {
+		return vars, st
+	}
+
+	old_c := cart
+	cart = st
+	out, _ := structFields(vars[0].Type.T)
+	cart = old_c
+
+	return append(append([]TVariable{}, out...), vars[1:]...), st
 }
 
 // Convert Value to Struct from Array (cvsa)
 // USE ONLY IN THE CASE OF tStruct!
 func cvsa(sct TArtifact, dat []interface{}) VarMap {
-	sv, sct := searchDef(sct)
+	vars, sct := structFields(sct)
 	
 	old_c := cart
 	cart = sct
 	
-	vars := sv.Data.([]TVariable)
 	if len(vars) != len(dat) {
 		return nil
 	}
@@ -511,7 +635,7 @@ func cata(st TArtifact, dat []interface{}) []interface{} {
 		case VarMap:
 			out = append(out, csts(st, v))
 		default:
-			out = append(out, convertValPS(TType{[]string{}, st, ""}, 0, v))
+			out = append(out, convertValPS(TType{[]string{}, st, "", nil}, 0, v))
 		}
 	}
 
@@ -521,11 +645,9 @@ func cata(st TArtifact, dat []interface{}) []interface{} {
 // Copy struct to struct
 // Makes a deep copy of a struct.
 func csts(st TArtifact, dat VarMap) VarMap {
-	sv, st := searchDef(st)
+	vars, st := structFields(st)
 	old_c := cart
 	cart = st
-	
-	vars := sv.Data.([]TVariable)
 
 	out := make(VarMap)
 
@@ -554,7 +676,22 @@ func convertValPS(to TType, sk int, dat interface{}) interface{} {
 		return dat
 	}
 
+	// Integers are converted exactly
+	if name, ok := intType(to, sk); ok {
+		if bits, _, isInt := intValue(dat); isInt {
+			return makeInt(name, bits)
+		}
+	}
+
 	var numcv float64
+	if bits, signed, isInt := intValue(dat); isInt {
+		numcv = float64(bits)
+		if signed {
+			numcv = float64(int64(bits))
+		}
+		goto NCV
+	}
+
 	switch v := dat.(type) {
 	case []interface{}:
 		if isArray(to, sk) {
@@ -564,18 +701,17 @@ func convertValPS(to TType, sk int, dat interface{}) interface{} {
 		}
 	case VarMap:
 		return csts(to.T, v)
-	case int:
-		numcv = float64(v)
-		goto NCV
-	case uint:
-		numcv = float64(v)
-		goto NCV
-	case byte:
-		numcv = float64(v)
-		goto NCV
+	case *TVariable:
+		// Already boxed by convertVal
+		if isInterface(to, sk) {
+			return v
+		}
 	case float64:
 		numcv = v
 		goto NCV
+	case float32:
+		numcv = float64(v)
+		goto NCV
 	case bool:
 		numcv = 0
 		if v {
@@ -588,14 +724,13 @@ func convertValPS(to TType, sk int, dat interface{}) interface{} {
 	return nil
 
 	NCV:
-	if equateTypePSO(to, tInt, sk) {
-		return int(numcv)
-	} else if equateTypePSO(to, tUint, sk) {
-		return uint(numcv)
-	} else if equateTypePSO(to, tFloat, sk) {
-		return float64(numcv)
-	} else if equateTypePSO(to, tByte, sk) {
-		return byte(numcv)
+	if name, ok := intType(to, sk); ok {
+		return makeInt(name, uint64(int64(numcv)))
+	} else if name, ok := floatType(to, sk); ok {
+		if name == "float32" {
+			return float32(numcv)
+		}
+		return numcv
 	} else if equateTypePSO(to, tBool, sk) {
 		return numcv != 0
 	}
@@ -605,9 +740,52 @@ func convertValPS(to TType, sk int, dat interface{}) interface{} {
 }
 
 func convertVal(dat *TVariable, to TType) *TVariable {
+	if isStruct(to, 0) && isInterface(to, 0) {
+		return &TVariable{to, boxInterface(dat, to)}
+	}
 	return &TVariable{to, convertValPS(to, 0, dat.Data)}
 }
 
+// The value new memory of a type starts with
+func zeroValue(t TType) interface{} {
+	if name, ok := intType(t, 0); ok {
+		return makeInt(name, 0)
+	} else if _, ok := floatType(t, 0); ok {
+		return convertValPS(t, 0, 0.0)
+	} else if equateType(t, tBool) {
+		return false
+	} else if isArray(t, 0) {
+		// Strings and arrays without a fixed length start empty
+		out := []interface{}{}
+		for i := 0; i < arrayLen(t, 0); i++ {
+			out = append(out, zeroValue(stripType(t, 1)))
+		}
+		return out
+	} else if isStruct(t, 0) && !isInterface(t, 0) {
+		return zeroStruct(t.T)
+	}
+	return nil
+}
+
+// A struct with each of its members set to their zero value
+func zeroStruct(st TArtifact) interface{} {
+	if sv, _ := searchDef(st); sv == nil || !equateType(sv.Type, tStruct) {
+		return nil
+	}
+
+	vars, st := structFields(st)
+	old_c := cart
+	cart = st
+
+	out := make(VarMap)
+	for _, v := range vars {
+		out[v.Data.(string)] = &TVariable{v.Type, zeroValue(v.Type)}
+	}
+
+	cart = old_c
+	return out
+}
+
 //#####################
 //# Finding Artifacts #
 //#####################
@@ -658,28 +836,22 @@ func resolveStructCall(a TArtifact, method string, params []TVariable) TVariable
 		}
 	}
 
-	blk, pth := searchNode(a)
+	blk, pth := findMethod(a, method)
 
-	if blk == nil {
-		errOut(fmt.Sprintf("Could not find a method block for given type %v", a))
-	}
-
-	for i := 0; i < len(blk.Sub); i++ {
-		if getBlockName(blk.Sub[i])[0] == method {
-			ocrt := cart
-			cart = pth
-			out := evalBlock(blk.Sub[i], params, true)
-			cart = ocrt
-			return out
-		}
+	if blk != nil {
+		ocrt := cart
+		cart = pth
+		out := evalBlock(*blk, params, true)
+		cart = ocrt
+		return out
 	}
 
 	errOut(fmt.Sprintf("Could not find method %s in type %v", method, a))
 	return null
 }
 
-func resolveArtifact(a TArtifact, ctx *VarMap) *TVariable {
-	val, prs := (*ctx)[a.Name]
+func resolveArtifact(a TArtifact, ctx *Scope) *TVariable {
+	val, prs := ctx.get(a.Name)
 	if !prs || len(a.Path) != 0 {
 		// Try searching the modules for it
 		val, _ = searchDef(a)
@@ -706,6 +878,10 @@ func isStruct(t TType, skp int) bool {
 	ch = ch || equateTypePSO(t, tNull, skp)
 	ch = ch || equateTypePSO(t, tUint, skp)
 
+	_, isInt := intType(t, skp)
+	_, isFloat := floatType(t, skp)
+	ch = ch || isInt || isFloat
+
 	return !ch
 }
 
@@ -727,7 +903,7 @@ func isArray(t TType, skp int) bool {
 }
 
 // Deals with call and index nodes
-func evalCIN(v tparse.Node, ctx *VarMap, wk *TVariable) *TVariable {
+func evalCIN(v tparse.Node, ctx *Scope, wk *TVariable) *TVariable {
 	if v.Sub[0].Data.Data == "call" {
 		if v.Data.Data == "append" && isArray(wk.Type, 0) {
 			tmp := convertVal(evalValue(v.Sub[0].Sub[0], ctx), stripType(wk.Type, 1))
@@ -737,6 +913,10 @@ func evalCIN(v tparse.Node, ctx *VarMap, wk *TVariable) *TVariable {
 		}
 
 		args := []TVariable{}
+
+		if wk != nil && wk.Data != nil && isInterface(wk.Type, 0) {
+			wk = unboxInterface(wk, v)
+		}
 		
 		pth := TArtifact{[]string{}, v.Data.Data}
 		if wk != nil {
@@ -769,23 +949,30 @@ func evalCIN(v tparse.Node, ctx *VarMap, wk *TVariable) *TVariable {
 			wk.Type = stripType(wk.Type, 1)
 		case "`":
 			// De-reference
+			checkDeref(*(wk.Data.(*interface{})), v.Sub[i])
 			wk.Data = (*(wk.Data.(*interface{})))
 			wk.Type = stripType(wk.Type, 1)
+		case "cast":
+			// The cast value is a copy, changing it does not change the variable
+			tmp := castValue(&TVariable{wk.Type, *(wk.Data.(*interface{}))}, v.Sub[i])
+			wk = &TVariable{tmp.Type, &(tmp.Data)}
 		}
 	}
 
 	return wk
 }
 
-func evalDotChain(v tparse.Node, ctx *VarMap) *TVariable {
+func evalDotChain(v tparse.Node, ctx *Scope) *TVariable {
 	var out *TVariable = nil
 	wnd := &(v.Sub[0])
 
-	if wnd.Data.Data == "self" {
+	if wnd.Data.Data == "self" || wnd.Data.Data == "super" {
 		var prs bool
-		out, prs = (*ctx)["self"]
+		out, prs = ctx.get("self")
 		if !prs {
-			errOutNode("Use of 'self' keyword outside of method block.", v)
+			errOutNode(fmt.Sprintf("Use of '%s' keyword outside of method block.", wnd.Data.Data), v)
+		} else if wnd.Data.Data == "super" {
+			out = superOf(out, *wnd)
 		}
 
 		v = v.Sub[1]
@@ -815,7 +1002,7 @@ func evalDotChain(v tparse.Node, ctx *VarMap) *TVariable {
 		if len(wnd.Sub) > 0 {
 			if out == nil {
 				if wnd.Sub[0].Data.Data == "call" {
-					out = evalCIN(*wnd, ctx, &TVariable{TType{[]string{}, wrk, ""}, nil})
+					out = evalCIN(*wnd, ctx, &TVariable{TType{[]string{}, wrk, "", nil}, nil})
 				} else {
 					errOutNode("Attempt to index/deref a variable that could not be found", *wnd)
 				}
@@ -823,11 +1010,9 @@ func evalDotChain(v tparse.Node, ctx *VarMap) *TVariable {
 				out = evalCIN(*wnd, ctx, out)
 
 				if wnd.Sub[len(wnd.Sub) - 1].Data.Data == "++" {
-					val := convertValPS(tFloat, 0, *(out.Data.(*interface{}))).(float64) + 1
-					*(out.Data.(*interface{})) = convertValPS(out.Type, 0, val)
+					*(out.Data.(*interface{})) = convertValPS(out.Type, 0, stepRef(out, "+").Data)
 				} else if wnd.Sub[len(wnd.Sub) - 1].Data.Data == "--" {
-					val := convertValPS(tFloat, 0, *(out.Data.(*interface{}))).(float64) - 1
-					*(out.Data.(*interface{})) = convertValPS(out.Type, 0, val)
+					*(out.Data.(*interface{})) = convertValPS(out.Type, 0, stepRef(out, "-").Data)
 				}
 			}
 		}
@@ -850,23 +1035,20 @@ func evalDotChain(v tparse.Node, ctx *VarMap) *TVariable {
 	return out
 }
 
-func setVal(v tparse.Node, ctx *VarMap, val *TVariable) *TVariable {
-	var wrk *TVariable = nil
-
+// Find the variable a value refers to so it can be changed.  Local variables are
+// searched first, then the module globals.
+func getRef(v tparse.Node, ctx *Scope) *TVariable {
+	var wrk *TVariable
 	if v.Data.Data == "." {
 		wrk = evalDotChain(v, ctx)
 		
 		if wrk == nil {
 			errOutNode("Unable to set a variable who's type is null. (Did you make a function call somewhere?)", v)
 		}
-		
-		for ;v.Data.Data == "."; {
-			v = v.Sub[1]
-		}
 	} else {
-		tmp, prs := (*ctx)[v.Data.Data]
+		tmp := resolveArtifact(TArtifact{[]string{}, v.Data.Data}, ctx)
 
-		if !prs {
+		if tmp == nil {
 			errOutCTX("Unable to set a variable due to the variable not existing.", ctx)
 		}
 
@@ -877,21 +1059,46 @@ func setVal(v tparse.Node, ctx *VarMap, val *TVariable) *TVariable {
 		}
 	}
 
+	if p, ok := wrk.Data.(*interface{}); ok && constData[p] {
+		for ;v.Data.Data == "."; {
+			v = v.Sub[1]
+		}
+		errOutNode(fmt.Sprintf("Can not assign to %s, it is const", v.Data.Data), v)
+	}
+
+	return wrk
+}
+
+func setVal(v tparse.Node, ctx *Scope, val *TVariable) *TVariable {
+	wrk := getRef(v, ctx)
+
+	for ;v.Data.Data == "."; {
+		v = v.Sub[1]
+	}
+
 	if len(v.Sub) > 0 {
 		if v.Sub[len(v.Sub) - 1].Data.Data == "++" {
-			val = &TVariable{tFloat, convertValPS(tFloat, 0, *(wrk.Data.(*interface{}))).(float64) + 1}
+			val = stepRef(wrk, "+")
 		} else if v.Sub[len(v.Sub) - 1].Data.Data == "--" {
-			val = &TVariable{tFloat, convertValPS(tFloat, 0, *(wrk.Data.(*interface{}))).(float64) - 1}
+			val = stepRef(wrk, "-")
 		}
 	}
 
-	*(wrk.Data.(*interface{})) = convertValPS((*wrk).Type, 0, val.Data)
+	*(wrk.Data.(*interface{})) = convertVal(val, (*wrk).Type).Data
 	
 	return wrk
 }
 
 // Parse a value node
-func evalValue(v tparse.Node, ctx *VarMap) *TVariable {
+func evalValue(v tparse.Node, ctx *Scope) *TVariable {
+
+	// Casts on anything other than a variable (which evalCIN handles) come after its
+	// other sub-nodes, as in 3[float] or (a + b)[int]
+	if l := len(v.Sub); l > 0 && v.Data.Type != tparse.DEFWORD && isCast(v.Sub[l - 1]) {
+		base := v
+		base.Sub = v.Sub[:l - 1]
+		return castValue(evalValue(base, ctx), v.Sub[l - 1])
+	}
 
 	// STRUCT/ARRAY DEF
 	if v.Data.Data == "comp" {
@@ -907,10 +1114,12 @@ func evalValue(v tparse.Node, ctx *VarMap) *TVariable {
 
 	switch v.Data.Type {
 	case tparse.LITERAL:
-		if v.Data.Data == "self" {
-			s, prs := (*ctx)["self"]
+		if v.Data.Data == "self" || v.Data.Data == "super" {
+			s, prs := ctx.get("self")
 			if !prs {
-				errOutNode("Use of 'self' keyword when not in a method.", v)
+				errOutNode(fmt.Sprintf("Use of '%s' keyword when not in a method.", v.Data.Data), v)
+			} else if v.Data.Data == "super" {
+				s = superOf(s, v)
 			}
 			return &TVariable{s.Type, *(s.Data.(*interface{}))}
 		}
@@ -922,9 +1131,9 @@ func evalValue(v tparse.Node, ctx *VarMap) *TVariable {
 				return setVal(v, ctx, nil)
 			}
 
-			ref, prs := (*ctx)[v.Data.Data]
+			ref := resolveArtifact(TArtifact{[]string{}, v.Data.Data}, ctx)
 
-			if !prs {
+			if ref == nil {
 				ref = evalCIN(v, ctx, nil)
 			} else {
 				ref = evalCIN(v, ctx, &TVariable{ref.Type, &(ref.Data)})
@@ -939,7 +1148,15 @@ func evalValue(v tparse.Node, ctx *VarMap) *TVariable {
 		// Special cases
 		switch v.Data.Data {
 		case "=":
-			return setVal(v.Sub[0], ctx, evalValue(v.Sub[1], ctx))
+			// Give the value stored so chains like a = b = c work
+			ref := setVal(v.Sub[0], ctx, evalValue(v.Sub[1], ctx))
+			return &TVariable{ref.Type, *(ref.Data.(*interface{}))}
+		case "+=", "-=", "*=", "/=", "%=", "&=", "|=", "^=", "~=", "`=":
+			ref := evalCompound(v, ctx)
+			return &TVariable{ref.Type, *(ref.Data.(*interface{}))}
+		case "is":
+			// The right side is a type, not a value
+			return &TVariable{tBool, isType(evalValue(v.Sub[0], ctx), getType(v.Sub[1]))}
 		case ".":
 			ref := evalDotChain(v, ctx)
 			if ref == nil {
@@ -949,113 +1166,210 @@ func evalValue(v tparse.Node, ctx *VarMap) *TVariable {
 		}
 
 		if len(v.Sub) == 1 {
+			a := evalValue(v.Sub[0], ctx)
+			if v.Data.Data != "~" {
+				if out, ok := callOperator(v.Data.Data, a, nil); ok {
+					return out
+				}
+			}
+
 			switch v.Data.Data {
 			case "!":
-				a := convertVal(evalValue(v.Sub[0], ctx), tBool)
+				if _, ok := intType(a.Type, 0); ok {
+					return evalComplement(a)
+				}
+				a = convertVal(a, tBool)
 				return &TVariable{tBool, !(a.Data.(bool))}
 			case "len":
-				a := evalValue(v.Sub[0], ctx)
 				return &TVariable{tInt, len(a.Data.([]interface{}))}
 			case "~":
-				a := evalValue(v.Sub[0], ctx)
 				typ := a.Type
 				typ.Pre = append([]string{"~"}, typ.Pre...)
+				if typ.Len != nil {
+					typ.Len = append([]int{0}, typ.Len...)
+				}
 				return &TVariable{typ, &(a.Data)}
 			case "-":
-				a := convertVal(evalValue(v.Sub[0], ctx), tFloat)
-				a.Data = -(a.Data.(float64))
-				return a
+				return evalNegate(a)
 			}
 		}
 
-		// General case setup
-		
-		a := convertVal(evalValue(v.Sub[0], ctx), tFloat)
-		b := convertVal(evalValue(v.Sub[1], ctx), tFloat)
-		var out TVariable
-		out.Type = tFloat
-
-		// General math and bool cases
-		switch v.Data.Data {
-		case "+":
-			out.Data = a.Data.(float64) + b.Data.(float64)
-		case "-":
-			out.Data = a.Data.(float64) - b.Data.(float64)
-		case "*":
-			out.Data = a.Data.(float64) * b.Data.(float64)
-		case "/":
-			out.Data = a.Data.(float64) / b.Data.(float64)
-		case "%":
-			out.Type = tInt
-			out.Data = int(a.Data.(float64)) % int(b.Data.(float64))
-		case "&&":
-			out.Type = tBool
-			out.Data = a.Data.(float64) == 1 && b.Data.(float64) == 1
-		case "||":
-			out.Type = tBool
-			out.Data = a.Data.(float64) == 1 || b.Data.(float64) == 1
-		case "==":
-			out.Type = tBool
-			out.Data = a.Data == b.Data
-		case "!==":
-			out.Type = tBool
-			out.Data = a.Data != b.Data
-		case ">":
-			out.Type = tBool
-			out.Data = a.Data.(float64) > b.Data.(float64)
-		case "<":
-			out.Type = tBool
-			out.Data = a.Data.(float64) < b.Data.(float64)
-		case "!>":
-			out.Type = tBool
-			out.Data = a.Data.(float64) <= b.Data.(float64)
-		case "!<":
-			out.Type = tBool
-			out.Data = a.Data.(float64) >= b.Data.(float64)
-		case ">==":
-			out.Type = tBool
-			out.Data = a.Data.(float64) >= b.Data.(float64)
-		case "<==":
-			out.Type = tBool
-			out.Data = a.Data.(float64) <= b.Data.(float64)
-		}
-
-		return &out
+		return evalBinary(v.Data.Data, evalValue(v.Sub[0], ctx), evalValue(v.Sub[1], ctx))
 	}
 
 	return &null
 }
 
+// Add a de-reference to the end of a value (or the last value in a get chain)
+func derefNode(v tparse.Node) tparse.Node {
+	if v.Data.Data == "." {
+		v.Sub = []tparse.Node{v.Sub[0], derefNode(v.Sub[1])}
+		return v
+	}
+
+	v.Sub = append(append([]tparse.Node{}, v.Sub...), tparse.Node{Data: tparse.Token{Type: tparse.AUGMENT, Data: "`"}})
+	return v
+}
+
+// Eval a compound assignment (a += b).  The variable is found once and changed in place.
+func evalCompound(v tparse.Node, ctx *Scope) *TVariable {
+	op := strings.TrimSuffix(v.Data.Data, "=")
+
+	switch op {
+	case "~":
+		// a ~= b is a = ~b
+		addr := tparse.Node{Data: tparse.Token{Type: tparse.AUGMENT, Data: "~"}, Sub: []tparse.Node{v.Sub[1]}}
+		return setVal(v.Sub[0], ctx, evalValue(addr, ctx))
+	case "`":
+		// a `= b is a` = b
+		return setVal(derefNode(v.Sub[0]), ctx, evalValue(v.Sub[1], ctx))
+	}
+
+	// Eval the right side first, it may move the variable (by appending to an array)
+	b := evalValue(v.Sub[1], ctx)
+	ref := getRef(v.Sub[0], ctx)
+	a := &TVariable{ref.Type, *(ref.Data.(*interface{}))}
+
+	*(ref.Data.(*interface{})) = convertValPS(ref.Type, 0, evalBinary(op, a, b).Data)
+
+	return ref
+}
+
+// Eval a binary operator (other than assignment and get)
+func evalBinary(op string, a, b *TVariable) *TVariable {
+	// Structs use their operator blocks.  a !== b is !(a == b) if there is no block for !==.
+	if out, ok := callOperator(op, a, b); ok {
+		return out
+	} else if op == "!==" {
+		if out, ok := callOperator("==", a, b); ok {
+			return &TVariable{tBool, !convertVal(out, tBool).Data.(bool)}
+		}
+	}
+
+	switch op {
+	case "&", "|", "^", "<<", ">>", "!&", "!|", "!^":
+		return evalBitwise(op, a, b)
+	}
+
+	// Integers are worked on exactly, anything else as a float
+	if isIntValue(a) && isIntValue(b) {
+		if out, ok := evalInt(op, a, b); ok {
+			return out
+		}
+	}
+
+	var out TVariable
+	out.Type = floatResult(a.Type, b.Type)
+	a = convertVal(a, tFloat)
+	b = convertVal(b, tFloat)
+
+	// General math and bool cases
+	switch op {
+	case "+":
+		out.Data = a.Data.(float64) + b.Data.(float64)
+	case "-":
+		out.Data = a.Data.(float64) - b.Data.(float64)
+	case "*":
+		out.Data = a.Data.(float64) * b.Data.(float64)
+	case "/":
+		out.Data = a.Data.(float64) / b.Data.(float64)
+	case "%":
+		if int(b.Data.(float64)) == 0 {
+			errOut(fmt.Sprintf("Division by zero (%v %% %v)", a.Data, b.Data))
+		}
+		out.Type = tInt
+		out.Data = int(a.Data.(float64)) % int(b.Data.(float64))
+	case "&&":
+		out.Type = tBool
+		out.Data = a.Data.(float64) == 1 && b.Data.(float64) == 1
+	case "||":
+		out.Type = tBool
+		out.Data = a.Data.(float64) == 1 || b.Data.(float64) == 1
+	case "==":
+		out.Type = tBool
+		out.Data = a.Data == b.Data
+	case "!==":
+		out.Type = tBool
+		out.Data = a.Data != b.Data
+	case ">":
+		out.Type = tBool
+		out.Data = a.Data.(float64) > b.Data.(float64)
+	case "<":
+		out.Type = tBool
+		out.Data = a.Data.(float64) < b.Data.(float64)
+	case "!>":
+		out.Type = tBool
+		out.Data = a.Data.(float64) <= b.Data.(float64)
+	case "!<":
+		out.Type = tBool
+		out.Data = a.Data.(float64) >= b.Data.(float64)
+	case ">==":
+		out.Type = tBool
+		out.Data = a.Data.(float64) >= b.Data.(float64)
+	case "<==":
+		out.Type = tBool
+		out.Data = a.Data.(float64) <= b.Data.(float64)
+	}
+
+	// float32 math is rounded after each operation
+	if name, _ := floatType(out.Type, 0); name == "float32" && out.Data != nil {
+		out.Data = float32(out.Data.(float64))
+	}
+
+	return &out
+}
+
 // Eval a definition
-func evalDef(v tparse.Node, ctx *VarMap) {
-	t := getType(v.Sub[0])
+func evalDef(v tparse.Node, ctx *Scope) {
+	t, isConst := splitConst(getType(v.Sub[0]))
 	
 	for i := 0; i < len(v.Sub[1].Sub); i++ {
 		if v.Sub[1].Sub[i].Data.Data == "=" {
-			(*ctx)[v.Sub[1].Sub[i].Sub[0].Data.Data] = convertVal(evalValue(v.Sub[1].Sub[i].Sub[1], ctx), t)
+			ctx.Vars[v.Sub[1].Sub[i].Sub[0].Data.Data] = convertVal(evalValue(v.Sub[1].Sub[i].Sub[1], ctx), t)
+			if isConst {
+				markConst(&(ctx.Vars[v.Sub[1].Sub[i].Sub[0].Data.Data].Data))
+			}
+		} else if tparse.ORDER[v.Sub[1].Sub[i].Data.Data] == tparse.ORDER["="] {
+			// Compound assignment, as in ;~int a ~= b
+			ctx.Vars[v.Sub[1].Sub[i].Sub[0].Data.Data] = &TVariable{t, zeroValue(t)}
+			evalCompound(v.Sub[1].Sub[i], ctx)
 		} else {
-			(*ctx)[v.Sub[1].Sub[i].Data.Data] = &TVariable{t, nil}
+			ctx.Vars[v.Sub[1].Sub[i].Data.Data] = &TVariable{t, zeroValue(t)}
 		}
 	}
 }
 
-// Eval a control flow
-func evalCF(v tparse.Node, ctx *VarMap) (bool, TVariable, int) {
+// Eval a control flow.  The block gets its own scope, which also holds the
+// definitions in its ().
+func evalCF(v tparse.Node, ctx *Scope) (bool, TVariable, int) {
+	pushScope()
+	defer popScope()
+	ctx = newScope(ctx)
 
 	loop := true
 	ifout := true
-	cond := tparse.Node{tparse.Token{tparse.LITERAL, "true", -1, -1}, []tparse.Node{}}
+	cond := tparse.Node{Data: tparse.Token{Type: tparse.LITERAL, Kind: tparse.BOOLLIT, Data: "true", Line: -1, Char: -1}}
 	var after *tparse.Node = nil
 
+	if isBlock(v, "match") {
+		return evalMatch(v, ctx)
+	}
+
 	if v.Sub[0].Data.Data == "bdef" {
 		var before *tparse.Node = nil
+		cased := false
 
 		for i := 0; i < len(v.Sub[0].Sub); i++ {
 			switch v.Sub[0].Sub[i].Data.Data {
 			case "if", "else":
 				loop = false
+			case "case", "default":
+				// The case values are checked by evalMatch
+				loop, cased = false, true
 			case "()":
-				before = &(v.Sub[0].Sub[i])
+				if !cased {
+					before = &(v.Sub[0].Sub[i])
+				}
 			case "[]":
 				after = &(v.Sub[0].Sub[i])
 			}
@@ -1088,12 +1402,24 @@ func evalCF(v tparse.Node, ctx *VarMap) (bool, TVariable, int) {
 				ret, val, brk := evalCF(v.Sub[i], ctx)
 				if ret {
 					return ret, val, 0
+				} else if equateType(val.Type, tGoto) {
+					j := findLabel(v, val.Data.(string))
+					if j < 0 {
+						return false, val, 0
+					}
+					i = j
 				} else if brk < 0 {
-					if brk < -1 {
+					// Only loops count towards the depth of a break or continue
+					if !loop {
+						return false, null, brk
+					} else if brk < -1 {
 						return false, null, brk + 1
 					}
 					goto CONCF
 				} else if brk > 0 {
+					if !loop {
+						return false, null, brk
+					}
 					return false, null, brk - 1
 				} else if equateType(val.Type, tIF) {
 					if val.Data.(bool) == true {
@@ -1117,6 +1443,16 @@ func evalCF(v tparse.Node, ctx *VarMap) (bool, TVariable, int) {
 					return true, *evalValue(v.Sub[i].Sub[0], ctx), 0
 				}
 				return true, null, 0
+			case "alloc", "salloc", "realloc", "delete":
+				evalHeap(v.Sub[i], ctx)
+			case "goto":
+				// Jump here if the label is in this block, otherwise leave it
+				name := v.Sub[i].Sub[0].Data.Data
+				j := findLabel(v, name)
+				if j < 0 {
+					return false, TVariable{tGoto, name}, 0
+				}
+				i = j
 			case "break":
 				brk := 0
 				if len(v.Sub[i].Sub) > 0 {
@@ -1142,6 +1478,9 @@ func evalCF(v tparse.Node, ctx *VarMap) (bool, TVariable, int) {
 
 		CONCF:
 
+		// salloc memory from this pass of the loop is freed before the next one
+		resetScope()
+
 		if after != nil {
 			for i := 0; i < len(after.Sub); i++ {
 				switch after.Sub[i].Data.Data {
@@ -1168,7 +1507,97 @@ func evalCF(v tparse.Node, ctx *VarMap) (bool, TVariable, int) {
 	return false, null, 0
 }
 
-func evalParams(pd tparse.Node, params *[]TVariable, ctx *VarMap, method bool) {
+// Is the node a block with the given keyword
+func isBlock(v tparse.Node, kw string) bool {
+	if v.Data.Data != "block" || len(v.Sub) == 0 || v.Sub[0].Data.Data != "bdef" {
+		return false
+	}
+	n := getBlockName(v)
+	return len(n) > 0 && n[0] == kw
+}
+
+// Index of the statement in a block which defines a label, or -1 if it is not there
+func findLabel(b tparse.Node, name string) int {
+	for i := 0; i < len(b.Sub); i++ {
+		if b.Sub[i].Data.Type == tparse.KEYWORD && b.Sub[i].Data.Data == "label" && b.Sub[i].Sub[0].Data.Data == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Are two values equal.  Arrays (and so strings) are equal if all their elements are.
+func valuesEqual(a, b *TVariable) bool {
+	x, xok := a.Data.([]interface{})
+	y, yok := b.Data.([]interface{})
+	if xok || yok {
+		if !xok || !yok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !valuesEqual(&TVariable{stripType(a.Type, 1), x[i]}, &TVariable{stripType(b.Type, 1), y[i]}) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return evalBinary("==", a, b).Data.(bool)
+}
+
+// Eval a match block.  The first case with a value equal to the match value is run,
+// or the default block if no case matches.  A break leaves the match.
+func evalMatch(v tparse.Node, ctx *Scope) (bool, TVariable, int) {
+	val := &null
+	for i := 0; i < len(v.Sub[0].Sub); i++ {
+		if v.Sub[0].Sub[i].Data.Data != "()" {
+			continue
+		}
+		vl := v.Sub[0].Sub[i]
+		for j := 0; j < len(vl.Sub); j++ {
+			switch vl.Sub[j].Data.Data {
+			case "define":
+				evalDef(vl.Sub[j], ctx)
+			case "value":
+				val = evalValue(vl.Sub[j].Sub[0], ctx)
+			}
+		}
+	}
+
+	var run, def *tparse.Node = nil, nil
+
+	CASES:
+	for i := 1; i < len(v.Sub); i++ {
+		if isBlock(v.Sub[i], "default") && def == nil {
+			def = &(v.Sub[i])
+		} else if isBlock(v.Sub[i], "case") {
+			for _, cv := range caseValues(v.Sub[i]) {
+				if valuesEqual(val, evalValue(cv, ctx)) {
+					run = &(v.Sub[i])
+					break CASES
+				}
+			}
+		}
+	}
+
+	if run == nil {
+		run = def
+	}
+
+	if run == nil {
+		return false, null, 0
+	}
+
+	ret, out, brk := evalCF(*run, ctx)
+	if ret || equateType(out.Type, tGoto) {
+		return ret, out, 0
+	} else if brk > 0 {
+		return false, null, brk - 1
+	}
+	return false, null, brk
+}
+
+func evalParams(pd tparse.Node, params *[]TVariable, ctx *Scope, method bool) {
 	if len(pd.Sub) == 0 {
 		return
 	}
@@ -1183,19 +1612,21 @@ func evalParams(pd tparse.Node, params *[]TVariable, ctx *VarMap, method bool) {
 		if pd.Sub[i].Data.Type == 10 && pd.Sub[i].Data.Data == "type" {
 			cvt = getType(pd.Sub[i])
 		} else if pd.Sub[i].Data.Type == tparse.DEFWORD {
-			(*ctx)[pd.Sub[i].Data.Data] = convertVal(&(*params)[pi], cvt)
+			ctx.Vars[pd.Sub[i].Data.Data] = convertVal(&(*params)[pi], cvt)
 			pi++
 		}
 	}
 }
 
 func evalBlock(b tparse.Node, params []TVariable, method bool) TVariable {
-	ctx := make(VarMap)
+	ctx := newScope(nil)
+	pushScope()
+	defer popScope()
 
 	var rty TType = tNull
 
 	if method {
-		ctx["self"] = &(params[0])
+		ctx.Vars["self"] = &(params[0])
 	}
 
 	if b.Sub[0].Data.Data == "bdef" {
@@ -1203,7 +1634,7 @@ func evalBlock(b tparse.Node, params []TVariable, method bool) TVariable {
 			if b.Sub[0].Sub[i].Data.Data == "[]" {
 				rty = getType(b.Sub[0].Sub[i])
 			} else if b.Sub[0].Sub[i].Data.Data == "()" {
-				evalParams(b.Sub[0].Sub[i], &params, &ctx, method)
+				evalParams(b.Sub[0].Sub[i], &params, ctx, method)
 			}
 		}
 	}
@@ -1211,13 +1642,15 @@ func evalBlock(b tparse.Node, params []TVariable, method bool) TVariable {
 	for i := 0; i < len(b.Sub); i++ {
 		switch b.Sub[i].Data.Data {
 		case "define":
-			evalDef(b.Sub[i], &ctx)
+			evalDef(b.Sub[i], ctx)
 		case "value":
-			evalValue(b.Sub[i].Sub[0], &ctx)
+			evalValue(b.Sub[i].Sub[0], ctx)
 		case "block":
-			ret, val, _ := evalCF(b.Sub[i], &ctx)
+			ret, val, _ := evalCF(b.Sub[i], ctx)
 			if ret {
 				return *convertVal(&val, rty)
+			} else if equateType(val.Type, tGoto) {
+				i = gotoLabel(b, val.Data.(string))
 			} else if equateType(val.Type, tIF) {
 				if val.Data.(bool) == true {
 					i++
@@ -1236,16 +1669,31 @@ func evalBlock(b tparse.Node, params []TVariable, method bool) TVariable {
 				}
 			}
 		case "return":
-			return *convertVal(evalValue(b.Sub[i].Sub[0], &ctx), rty)
+			return *convertVal(evalValue(b.Sub[i].Sub[0], ctx), rty)
+		case "alloc", "salloc", "realloc", "delete":
+			evalHeap(b.Sub[i], ctx)
+		case "goto":
+			i = gotoLabel(b, b.Sub[i].Sub[0].Data.Data)
 		}
 	}
 
 	return null
 }
 
+// Find the label a goto jumps to in a function body.  checkFile should catch a missing
+// label before the program runs, but stop here just in case.
+func gotoLabel(b tparse.Node, name string) int {
+	i := findLabel(b, name)
+	if i < 0 {
+		errOut(fmt.Sprintf("Could not find label %s for goto in %v", name, getNames(b)))
+	}
+	return i
+}
+
 func EvalTNSL(root *TModule, args string) TVariable {
 	prog = root
 	cart = TArtifact { []string{}, "main" }
+	initGlobals()
 
 	sarg := strings.Split(args, " ")
 
@@ -1266,7 +1714,8 @@ func EvalTNSL(root *TModule, args string) TVariable {
 		TType {
 			[]string{"{}", "{}"},
 			TArtifact { []string{}, "charp" },
-			"" },
+			"",
+			nil },
 		saif }
 
 	mainNode := getNode(prog, "main")
@@ -0,0 +1,239 @@
+/*
+   Copyright 2020 Kyle Gunger
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package texec
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"tparse"
+)
+
+/**
+	heap.go - memory made with alloc, salloc, and realloc, and freed with delete.
+
+	Each piece of memory is a new interface{} which pointers point to, the same as the
+	pointers made with ~.  The heap keeps a record of each one so delete can tell if it
+	was allocated.  Memory from salloc is freed when the block it was made in ends, or
+	for a loop at the end of each pass.
+
+	When CheckHeap is set the records of freed memory are kept, so using memory after it
+	was freed, freeing it twice, and memory which is never freed (leaks) can be reported.
+*/
+
+var (
+	CheckHeap = false
+)
+
+// A piece of memory on the heap
+type allocation struct {
+	Type  TType
+	// The alloc, salloc, or realloc statement which made it
+	At    tparse.Token
+	// Made by salloc, freed at the end of its block
+	Stack bool
+	Freed bool
+}
+
+var (
+	heap = map[*interface{}]*allocation{}
+
+	// Memory from salloc for each block being run
+	scopes = [][]*interface{}{}
+)
+
+// Write a type as it would be in TNSL source ({}~int, mod.Type)
+func typeString(t TType) string {
+	return strings.Join(t.Pre, "") + strings.Join(append(append([]string{}, t.T.Path...), t.T.Name), ".") + t.Post
+}
+
+// Make memory for a value of type t
+func heapAlloc(t TType, at tparse.Token, stack bool) *interface{} {
+	p := new(interface{})
+	*p = zeroValue(t)
+	heap[p] = &allocation{t, at, stack, false}
+
+	if stack {
+		scopes[len(scopes) - 1] = append(scopes[len(scopes) - 1], p)
+	}
+
+	return p
+}
+
+func heapFree(p *interface{}) {
+	if CheckHeap {
+		heap[p].Freed = true
+	} else {
+		delete(heap, p)
+	}
+}
+
+// Start a block, salloc memory made in it will be freed by popScope
+func pushScope() {
+	scopes = append(scopes, []*interface{}{})
+}
+
+// End a block, freeing its salloc memory
+func popScope() {
+	for _, p := range scopes[len(scopes) - 1] {
+		heapFree(p)
+	}
+	scopes = scopes[:len(scopes) - 1]
+}
+
+// Free the salloc memory made in the current block so far, keeping the block open
+func resetScope() {
+	popScope()
+	pushScope()
+}
+
+// Check a pointer before it is de-referenced
+func checkDeref(p interface{}, at tparse.Node) {
+	ptr, ok := p.(*interface{})
+	if p == nil || (ok && ptr == nil) {
+		errOutNode("De-reference of a null pointer", at)
+	}
+
+	if a, prs := heap[ptr]; ok && prs && a.Freed {
+		errOutNode(fmt.Sprintf("Use of memory after it was freed (%s allocated at line %d char %d)", typeString(a.Type), a.At.Line, a.At.Char + 1), at)
+	}
+}
+
+// Find the pointer variable a value in an alloc, salloc, realloc, or delete statement
+// refers to.  Returns nil if the value is not a pointer (so it is a length).
+func heapRef(v tparse.Node, ctx *Scope) *TVariable {
+	if v.Data.Type != tparse.DEFWORD && v.Data.Data != "." {
+		return nil
+	}
+
+	ref := getRef(v, ctx)
+	if !isPointer(ref.Type, 0) {
+		return nil
+	}
+	return ref
+}
+
+// Set the length of the array a pointer points to, moving it to new memory for realloc
+func heapResize(ref *TVariable, n int, kw tparse.Token, at tparse.Node) {
+	t := stripType(ref.Type, 1)
+	if !isArray(t, 0) {
+		errOutNode(fmt.Sprintf("A length can only be given for an array, but the pointer is to %s", typeString(t)), at)
+	} else if n < 0 {
+		errOutNode(fmt.Sprintf("Can not %s an array with a negative length (%d)", kw.Data, n), at)
+	}
+
+	p := (*(ref.Data.(*interface{}))).(*interface{})
+	old := (*p).([]interface{})
+
+	if kw.Data == "realloc" {
+		heapFree(p)
+		p = heapAlloc(t, kw, false)
+		*(ref.Data.(*interface{})) = p
+	}
+
+	arr := []interface{}{}
+	for i := 0; i < n; i++ {
+		if i < len(old) {
+			arr = append(arr, old[i])
+		} else {
+			arr = append(arr, zeroValue(stripType(t, 1)))
+		}
+	}
+	*p = arr
+}
+
+// Eval an alloc, salloc, realloc, or delete statement.  Each value is a pointer which is
+// given new memory (or has its memory freed), and may be followed by a length if the
+// pointer is to an array:
+//	;alloc a, b, 10
+//	;realloc b, 20
+//	;delete a, b
+func evalHeap(v tparse.Node, ctx *Scope) {
+	kw := v.Data
+	var last *TVariable = nil
+
+	for _, n := range v.Sub[0].Sub {
+		ref := heapRef(n, ctx)
+
+		if ref == nil {
+			if last == nil || kw.Data == "delete" {
+				errOutNode(fmt.Sprintf("Expected a pointer to %s", kw.Data), n)
+			}
+			heapResize(last, convertVal(evalValue(n, ctx), tInt).Data.(int), kw, n)
+			last = nil
+			continue
+		}
+
+		p, _ := (*(ref.Data.(*interface{}))).(*interface{})
+		a, prs := heap[p]
+
+		switch kw.Data {
+		case "alloc", "salloc":
+			*(ref.Data.(*interface{})) = heapAlloc(stripType(ref.Type, 1), kw, kw.Data == "salloc")
+		case "realloc":
+			// realloc of a null pointer is the same as alloc
+			if p == nil {
+				*(ref.Data.(*interface{})) = heapAlloc(stripType(ref.Type, 1), kw, false)
+			} else if !prs || a.Freed {
+				errOutNode("Can not realloc memory which was not made with alloc (or was freed)", n)
+			} else if a.Stack {
+				errOutNode("Can not realloc memory made with salloc", n)
+			}
+		case "delete":
+			if p == nil {
+				errOutNode("Can not delete a null pointer", n)
+			} else if !prs {
+				errOutNode("Can not delete memory which was not made with alloc (or was already freed)", n)
+			} else if a.Freed {
+				errOutNode(fmt.Sprintf("Double free of memory (%s allocated at line %d char %d)", typeString(a.Type), a.At.Line, a.At.Char + 1), n)
+			} else if a.Stack {
+				errOutNode("Can not delete memory made with salloc, it is freed at the end of its block", n)
+			}
+			heapFree(p)
+		}
+
+		last = ref
+	}
+}
+
+// HeapLeaks lists the memory made with alloc or realloc which was never freed.
+// Leaks are only reported when CheckHeap is set.
+func HeapLeaks() []string {
+	if !CheckHeap {
+		return []string{}
+	}
+
+	leaks := []*allocation{}
+	for _, a := range heap {
+		if !a.Freed && !a.Stack {
+			leaks = append(leaks, a)
+		}
+	}
+
+	sort.Slice(leaks, func(i, j int) bool {
+		if leaks[i].At.Line != leaks[j].At.Line {
+			return leaks[i].At.Line < leaks[j].At.Line
+		}
+		return leaks[i].At.Char < leaks[j].At.Char
+	})
+
+	out := []string{}
+	for _, a := range leaks {
+		out = append(out, fmt.Sprintf("Leak: %s allocated at line %d char %d was never deleted", typeString(a.Type), a.At.Line, a.At.Char + 1))
+	}
+	return out
+}
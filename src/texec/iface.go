@@ -0,0 +1,302 @@
+/*
+   Copyright 2020 Kyle Gunger
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package texec
+
+import (
+	"fmt"
+	"strings"
+	"tparse"
+)
+
+/**
+	iface.go - finding methods (including those from the structs a struct extends), and
+	interfaces.
+
+	An interface is a block of empty methods:
+		/; interface Shape
+			/; area [int]
+			;/
+		;/
+	Any struct with methods of the same names and signatures satisfies it.  A value in an
+	interface variable is boxed as a *TVariable holding the struct's own type, so method
+	calls can be sent to that type.
+*/
+
+// Does the definition of the block have the keyword
+func hasKeyword(b tparse.Node, kw string) bool {
+	if b.Data.Data != "block" || len(b.Sub) == 0 || b.Sub[0].Data.Data != "bdef" {
+		return false
+	}
+
+	for _, d := range b.Sub[0].Sub {
+		if d.Data.Type == tparse.KEYWORD && d.Data.Data == kw {
+			return true
+		}
+	}
+	return false
+}
+
+// Name of a method (or other named block), or "" if it has none
+func methodName(b tparse.Node) string {
+	if b.Data.Data != "block" || len(b.Sub) == 0 {
+		return ""
+	}
+
+	n := getBlockName(b)
+	if len(n) == 0 {
+		return ""
+	}
+	return n[0]
+}
+
+// Find the blocks with a keyword (method or interface) for a name, searching the same
+// way searchNode does.  Also gives the absolute path to the blocks.
+func searchKeywordBlocks(s TArtifact, kw string) ([]*tparse.Node, TArtifact) {
+	for i := len(cart.Path); i >= 0; i-- {
+		tst := getModuleRelative(getModuleInPath(i), s)
+		if tst == nil {
+			continue
+		}
+
+		out := []*tparse.Node{}
+		for j := 0; j < len(tst.Artifacts); j++ {
+			if hasKeyword(tst.Artifacts[j], kw) && methodName(tst.Artifacts[j]) == s.Name {
+				out = append(out, &(tst.Artifacts[j]))
+			}
+		}
+
+		if len(out) > 0 {
+			pth := append(append([]string{}, cart.Path[:i]...), s.Path...)
+			return out, TArtifact{pth, s.Name}
+		}
+	}
+
+	return nil, tNull.T
+}
+
+// Find a method of a type.  Methods may be split over more than one method block, but a
+// name may only be used twice if one of them is marked override, which replaces the other.
+// Methods are also found in the struct the type extends, and must be marked override to
+// replace one from there.  Returns nil if the method is not found.
+func findMethod(st TArtifact, name string) (*tparse.Node, TArtifact) {
+	blks, pth := searchKeywordBlocks(st, "method")
+	var found, over *tparse.Node = nil, nil
+
+	for _, b := range blks {
+		for i := 1; i < len(b.Sub); i++ {
+			if methodName(b.Sub[i]) != name {
+				continue
+			}
+
+			if !hasKeyword(b.Sub[i], "override") {
+				if found != nil {
+					errOutNode(fmt.Sprintf("Method %s is defined more than once for %s.  Mark the one to use with override.", name, typeString(TType{T: st})), b.Sub[i])
+				}
+				found = &(b.Sub[i])
+			} else if over != nil {
+				errOutNode(fmt.Sprintf("Method %s is overridden more than once for %s.", name, typeString(TType{T: st})), b.Sub[i])
+			} else {
+				over = &(b.Sub[i])
+			}
+		}
+	}
+
+	var inh *tparse.Node = nil
+	ipth := tNull.T
+	if parent, ok := structParent(st); ok {
+		inh, ipth = inParent(st, func() (*tparse.Node, TArtifact) {
+			return findMethod(parent.T, name)
+		})
+	}
+
+	if over != nil {
+		if found == nil && inh == nil {
+			errOutNode(fmt.Sprintf("Method %s of %s is marked override, but there is no method for it to replace.", name, typeString(TType{T: st})), *over)
+		}
+		return over, pth
+	} else if found != nil {
+		if inh != nil {
+			errOutNode(fmt.Sprintf("Method %s of %s replaces one from the struct it extends.  Mark it with override.", name, typeString(TType{T: st})), *found)
+		}
+		return found, pth
+	}
+	return inh, ipth
+}
+
+// Run a search from the module a struct is in, so the name of the struct it extends is
+// found the same way it was written
+func inParent(st TArtifact, search func() (*tparse.Node, TArtifact)) (*tparse.Node, TArtifact) {
+	_, abs := searchDef(st)
+	old_c := cart
+	cart = abs
+	out, pth := search()
+	cart = old_c
+	return out, pth
+}
+
+// The value of super in a method: self as the type the method's struct extends
+func superOf(self *TVariable, at tparse.Node) *TVariable {
+	parent, ok := structParent(TArtifact{[]string{}, cart.Name})
+	if !ok {
+		errOutNode(fmt.Sprintf("Use of super in a method of %s, which does not extend another struct", cart.Name), at)
+	}
+	return &TVariable{parent, self.Data}
+}
+
+// Find the block for an interface type, or nil if the type is not an interface
+func interfaceNode(t TType, sk int) *tparse.Node {
+	if len(t.Pre) != sk || t.Post != "" {
+		return nil
+	}
+
+	blks, _ := searchKeywordBlocks(t.T, "interface")
+	if len(blks) == 0 {
+		return nil
+	}
+	return blks[0]
+}
+
+func isInterface(t TType, sk int) bool {
+	return interfaceNode(t, sk) != nil
+}
+
+// The types of a method's parameters
+func methodParams(b tparse.Node) []TType {
+	out := []TType{}
+
+	for _, d := range b.Sub[0].Sub {
+		if d.Data.Data != "()" || d.Data.Type != 10 {
+			continue
+		}
+
+		var t TType
+		for _, p := range d.Sub {
+			if p.Data.Type == 10 && p.Data.Data == "type" {
+				t = getType(p)
+			} else if p.Data.Type == tparse.DEFWORD {
+				out = append(out, t)
+			}
+		}
+	}
+
+	return out
+}
+
+// The parameter and return types of a method, written like (int, ~Point) [int]
+func methodSig(b tparse.Node) string {
+	params, ret := []string{}, ""
+
+	for _, t := range methodParams(b) {
+		params = append(params, typeString(t))
+	}
+
+	for _, d := range b.Sub[0].Sub {
+		if d.Data.Data == "[]" {
+			ret = " [" + typeString(getType(d)) + "]"
+		}
+	}
+
+	return "(" + strings.Join(params, ", ") + ")" + ret
+}
+
+// List the reasons a struct type does not satisfy an interface (none if it does)
+func satisfies(st TType, iface *tparse.Node) []string {
+	out := []string{}
+
+	for i := 1; i < len(iface.Sub); i++ {
+		name := methodName(iface.Sub[i])
+		if name == "" {
+			continue
+		}
+
+		m, _ := findMethod(st.T, name)
+		if m == nil {
+			out = append(out, fmt.Sprintf("missing method %s", name))
+		} else if want, got := methodSig(iface.Sub[i]), methodSig(*m); want != got {
+			out = append(out, fmt.Sprintf("method %s is %s but should be %s", name, got, want))
+		}
+	}
+
+	return out
+}
+
+// Eval a is t: the value has type t, extends it, or (for an interface) satisfies it.
+// A value in an interface variable is checked by the type of the value it holds.
+func isType(a *TVariable, t TType) bool {
+	if box, boxed := a.Data.(*TVariable); boxed && !equateType(a.Type, t) {
+		a = box
+	}
+
+	for at := a.Type; ; {
+		if equateType(at, t) {
+			return true
+		}
+
+		p, ok := structParent(at.T)
+		if !ok || len(at.Pre) != 0 {
+			break
+		}
+		at = p
+	}
+
+	if iface := interfaceNode(t, 0); iface != nil && isStruct(a.Type, 0) && !equateType(a.Type, tStruct) {
+		return len(satisfies(a.Type, iface)) == 0
+	}
+	return false
+}
+
+// Box a value so it can be stored in an interface variable
+func boxInterface(dat *TVariable, to TType) interface{} {
+	if dat.Data == nil {
+		return nil
+	}
+
+	// Interfaces hold a copy of the value, like any other variable
+	box, boxed := dat.Data.(*TVariable)
+	if !boxed {
+		if !isStruct(dat.Type, 0) || equateType(dat.Type, tStruct) {
+			errOut(fmt.Sprintf("Only a value with a struct type can be stored in interface %s, but was given %v (type %s)", typeString(to), dat.Data, typeString(dat.Type)))
+		}
+		box = dat
+	}
+	box = &TVariable{box.Type, convertValPS(box.Type, 0, box.Data)}
+
+	if why := satisfies(box.Type, interfaceNode(to, 0)); len(why) > 0 {
+		errOut(fmt.Sprintf("%s does not satisfy interface %s: %s", typeString(box.Type), typeString(to), strings.Join(why, ", ")))
+	}
+
+	return box
+}
+
+// Get the value in an interface variable so a method can be called on it
+func unboxInterface(wk *TVariable, call tparse.Node) *TVariable {
+	iface := interfaceNode(wk.Type, 0)
+	box, _ := (*(wk.Data.(*interface{}))).(*TVariable)
+
+	found := false
+	for i := 1; i < len(iface.Sub); i++ {
+		found = found || methodName(iface.Sub[i]) == call.Data.Data
+	}
+
+	if !found {
+		errOutNode(fmt.Sprintf("%s is not a method of interface %s", call.Data.Data, typeString(wk.Type)), call)
+	} else if box == nil {
+		errOutNode(fmt.Sprintf("Call of method %s on a null %s", call.Data.Data, typeString(wk.Type)), call)
+	}
+
+	return &TVariable{box.Type, &(box.Data)}
+}
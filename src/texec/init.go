@@ -0,0 +1,287 @@
+/*
+   Copyright 2020 Kyle Gunger
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package texec
+
+import (
+	"fmt"
+	"tparse"
+)
+
+/**
+	init.go - give module level variables their values before main runs.
+
+	A value which is a single literal is set when the module is built.  Anything else
+	(arithmetic, other globals, composite values, enum values) is kept until the whole
+	program is loaded, then evaluated in an order where each variable comes after the
+	ones its value uses.  Values which use each other in a cycle are reported as errors,
+	as are values which call functions (the globals a function uses can not be seen from
+	the value, so there would be no way to order it).  Variables defined without a value
+	get the zero value of their type.
+*/
+
+// A module level variable waiting for its value
+type globalInit struct {
+	Name  tparse.Token
+	// The value from the definition (nil for the zero value)
+	Value *tparse.Node
+	File  string
+	Const bool
+	// Path of the module the variable is in (set by orderInits)
+	Path  []string
+	// The definition the value came from, kept so the module can be written out
+	Def   tparse.Node
+}
+
+// A module level variable used in a value, and where it was used.  To is nil if the
+// name is not a variable.
+type initRef struct {
+	To *TVariable
+	At tparse.Token
+}
+
+var (
+	inits = map[*TVariable]*globalInit{}
+
+	// The order to give the variables their values in
+	initOrder = []*TVariable{}
+)
+
+// Keep a variable's value to be evaluated before main runs.  def is the = node
+// from the definition, or just the variable's name.
+func deferInit(v *TVariable, def tparse.Node, isConst bool) {
+	in := &globalInit{Name: def.Data, File: loading, Const: isConst, Def: def}
+	if def.Data.Data == "=" {
+		in.Name, in.Value = def.Sub[0].Data, &(def.Sub[1])
+	}
+	inits[v] = in
+}
+
+// Is the value a single literal, which can be set without evaluating anything
+func isLiteral(n tparse.Node) bool {
+	return n.Data.Type == tparse.LITERAL && n.Data.Kind != 0 && len(n.Sub) == 0
+}
+
+// Find the variables in a module (and its sub-modules) which are waiting for values
+func collectInits(m *TModule, path []string, out *[]*TVariable) {
+	add := func(v *TVariable) {
+		if in, prs := inits[v]; prs {
+			in.Path = path
+			*out = append(*out, v)
+		}
+	}
+
+	for _, k := range defNames(*m) {
+		v := m.Defs[k]
+		add(v)
+		if equateType(v.Type, tEnum) {
+			members := v.Data.(VarMap)
+			for _, e := range defNames(TModule{Defs: members}) {
+				add(members[e])
+			}
+		}
+	}
+
+	for i := range m.Sub {
+		collectInits(&(m.Sub[i]), append(append([]string{}, path...), m.Sub[i].Name), out)
+	}
+}
+
+// Is the node a call, not a variable
+func isCall(n tparse.Node) bool {
+	return len(n.Sub) > 0 && n.Sub[0].Data.Data == "call"
+}
+
+// The first call in a value, if it has one
+func findCall(n tparse.Node) *tparse.Token {
+	var out *tparse.Token
+	tparse.Inspect(&n, func(c *tparse.Node) bool {
+		if c != nil && out == nil && c.Data.Type == tparse.DEFWORD && isCall(*c) {
+			out = &(c.Data)
+		}
+		return out == nil
+	})
+	return out
+}
+
+// The module variables used in a value.  cart must be set to the module the value is in.
+func initRefs(n tparse.Node) []initRef {
+	out := []initRef{}
+
+	if n.Data.Type == tparse.AUGMENT && n.Data.Data == "." {
+		segs := []tparse.Node{}
+		for c := n; ; c = c.Sub[1] {
+			if c.Data.Type != tparse.AUGMENT || c.Data.Data != "." {
+				segs = append(segs, c)
+				break
+			}
+			segs = append(segs, c.Sub[0])
+		}
+
+		names := []string{}
+		for _, s := range segs {
+			names = append(names, s.Data.Data)
+		}
+
+		// The shortest path naming a variable (mod.sub.var or var.member)
+		for k := 1; k <= len(names); k++ {
+			v, _ := searchDef(TArtifact{names[:k - 1], names[k - 1]})
+			if v == nil {
+				if k == len(names) && !isCall(segs[k - 1]) {
+					out = append(out, initRef{nil, segs[k - 1].Data})
+				}
+				continue
+			}
+
+			if equateType(v.Type, tEnum) && k < len(names) {
+				if e, prs := v.Data.(VarMap)[names[k]]; prs {
+					v = e
+				}
+			}
+			out = append(out, initRef{v, segs[k - 1].Data})
+			break
+		}
+
+		for _, s := range segs {
+			for _, sub := range s.Sub {
+				out = append(out, initRefs(sub)...)
+			}
+		}
+		return out
+	}
+
+	if n.Data.Type == tparse.DEFWORD {
+		if v, _ := searchDef(TArtifact{[]string{}, n.Data.Data}); v != nil {
+			out = append(out, initRef{v, n.Data})
+		} else if !isCall(n) {
+			out = append(out, initRef{nil, n.Data})
+		}
+	}
+
+	for _, s := range n.Sub {
+		out = append(out, initRefs(s)...)
+	}
+	return out
+}
+
+// Work out the order to give module variables their values in.  Returns an error for
+// each cycle of variables whose values use each other.
+func orderInits(root *TModule) []tparse.Diagnostic {
+	out := []tparse.Diagnostic{}
+
+	oprog, ocart := prog, cart
+	prog = root
+	defer func() { prog, cart = oprog, ocart }()
+
+	all := []*TVariable{}
+	collectInits(root, []string{}, &all)
+
+	// 1 while the variables a value uses are being ordered, 2 once it is in the order
+	state := map[*TVariable]int{}
+	initOrder = []*TVariable{}
+
+	// The variables being ordered and where each uses the next one
+	type step struct {
+		From *TVariable
+		At   tparse.Token
+	}
+	stack := []step{}
+
+	use := func(s step, to *TVariable) string {
+		in := inits[s.From]
+		return fmt.Sprintf("%s uses %s (%s:%d:%d)", in.Name.Data, inits[to].Name.Data, in.File, s.At.Line, s.At.Char + 1)
+	}
+
+	var visit func(v *TVariable)
+	visit = func(v *TVariable) {
+		in, prs := inits[v]
+		if !prs || state[v] == 2 {
+			return
+		}
+		state[v] = 1
+
+		refs := []initRef{}
+		if in.Value != nil {
+			cart = TArtifact{in.Path, ""}
+			refs = initRefs(*in.Value)
+
+			if call := findCall(*in.Value); call != nil {
+				msg := fmt.Sprintf("%s is called in the value of %s, but module variables can not call functions", call.Data, in.Name.Data)
+				out = append(out, tparse.Diagnostic{Message: msg, File: in.File, Line: call.Line, Col: call.Char + 1, Token: *call})
+			}
+		}
+
+		for _, r := range refs {
+			if r.To == nil {
+				msg := fmt.Sprintf("%s is not a module variable (used in the value of %s)", r.At.Data, in.Name.Data)
+				out = append(out, tparse.Diagnostic{Message: msg, File: in.File, Line: r.At.Line, Col: r.At.Char + 1, Token: r.At})
+			} else if state[r.To] == 1 {
+				// The cycle starts at the variable used, and ends with v using it
+				steps := []step{{v, r.At}}
+				for i := len(stack) - 1; r.To != v && i >= 0; i-- {
+					steps = append([]step{stack[i]}, steps...)
+					if stack[i].From == r.To {
+						break
+					}
+				}
+				msg := ""
+				for i, s := range steps {
+					if i > 0 {
+						msg += ", "
+					}
+					to := r.To
+					if i + 1 < len(steps) {
+						to = steps[i + 1].From
+					}
+					msg += use(s, to)
+				}
+
+				out = append(out, tparse.Diagnostic{Message: "Module variables used in a cycle: " + msg, File: in.File, Line: r.At.Line, Col: r.At.Char + 1, Token: r.At})
+			} else if _, prs := inits[r.To]; prs {
+				stack = append(stack, step{v, r.At})
+				visit(r.To)
+				stack = stack[:len(stack) - 1]
+			}
+		}
+
+		state[v] = 2
+		initOrder = append(initOrder, v)
+	}
+
+	for _, v := range all {
+		visit(v)
+	}
+
+	return out
+}
+
+// Give the module variables their values, in the order found by orderInits
+func initGlobals() {
+	ocart := cart
+	for _, v := range initOrder {
+		in := inits[v]
+		cart = TArtifact{in.Path, ""}
+		if in.Value == nil {
+			v.Data = zeroValue(v.Type)
+		} else {
+			v.Data = convertVal(evalValue(*in.Value, newScope(nil)), v.Type).Data
+		}
+		if in.Const {
+			markConst(&(v.Data))
+		}
+	}
+	cart = ocart
+}
@@ -59,6 +59,8 @@ var (
 
 	// Special types for if chain checking
 	tIF = TType{Pre: []string{}, T: TArtifact{Path: []string{}, Name: "if"}, Post: ""}
+	// Special type for a goto looking for its label, the data is the label's name
+	tGoto = TType{Pre: []string{}, T: TArtifact{Path: []string{}, Name: "goto"}, Post: ""}
 )
 
 // tells if the stub supports a function
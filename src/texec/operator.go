@@ -0,0 +1,150 @@
+/*
+   Copyright 2020 Kyle Gunger
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package texec
+
+import (
+	"fmt"
+	"tparse"
+)
+
+/**
+	operator.go - operators on structs, using the operator blocks in their method blocks.
+
+	A binary operator block takes one parameter (the right side) and a unary one takes
+	none.  self is a copy of the left side, so an operator block with no return type
+	gives back self after it has run:
+		/; method Vector2
+			/; operator + (Vector2 v) [Vector2]
+				;return {self.x + v.x, self.y + v.y}
+			;/
+			/; operator - [Vector2]
+				;return {-self.x, -self.y}
+			;/
+		;/
+*/
+
+// The operator an operator block overloads, or "" if it is not an operator block
+func operatorOf(b tparse.Node) string {
+	if b.Data.Data != "block" || len(b.Sub) == 0 || b.Sub[0].Data.Data != "bdef" {
+		return ""
+	}
+
+	for _, d := range b.Sub[0].Sub {
+		if d.Data.Type == tparse.AUGMENT || (d.Data.Type == tparse.KEYWORD && d.Data.Data == "delete") {
+			return d.Data.Data
+		}
+	}
+	return ""
+}
+
+// Does the block give a value back
+func hasReturn(b tparse.Node) bool {
+	for _, d := range b.Sub[0].Sub {
+		if d.Data.Data == "[]" && d.Data.Type == 10 {
+			return true
+		}
+	}
+	return false
+}
+
+// Find the operator block of a struct type for an operator and its arguments (none for a
+// unary operator).  If more than one block could take the arguments, the one whose
+// parameter has the same type as the argument is used, then the one marked override.
+// If the type has no block for the operator, the struct it extends is searched.
+// Returns nil if there is no such block.
+func findOperator(t TType, op string, args []*TVariable) (*tparse.Node, TArtifact) {
+	blks, pth := searchKeywordBlocks(t.T, "method")
+	cands := []*tparse.Node{}
+
+	for _, b := range blks {
+		for i := 1; i < len(b.Sub); i++ {
+			if operatorOf(b.Sub[i]) == op && len(methodParams(b.Sub[i])) == len(args) {
+				cands = append(cands, &(b.Sub[i]))
+			}
+		}
+	}
+
+	if len(cands) > 1 && len(args) > 0 {
+		exact := []*tparse.Node{}
+		for _, c := range cands {
+			if equateType(methodParams(*c)[0], args[0].Type) {
+				exact = append(exact, c)
+			}
+		}
+		if len(exact) > 0 {
+			cands = exact
+		}
+	}
+
+	if len(cands) > 1 {
+		over := []*tparse.Node{}
+		for _, c := range cands {
+			if hasKeyword(*c, "override") {
+				over = append(over, c)
+			}
+		}
+
+		if len(over) != 1 {
+			errOutNode(fmt.Sprintf("More than one operator %s block of %s could be used.  Mark the one to use with override.", op, typeString(t)), *cands[0])
+		}
+		cands = over
+	}
+
+	if len(cands) == 0 {
+		if parent, ok := structParent(t.T); ok {
+			return inParent(t.T, func() (*tparse.Node, TArtifact) {
+				return findOperator(parent, op, args)
+			})
+		}
+		return nil, pth
+	}
+	return cands[0], pth
+}
+
+// Call the operator block of a struct for a binary (b is given) or unary (b is nil) operator.
+// Returns false if a is not a struct or has no block for the operator.
+func callOperator(op string, a, b *TVariable) (*TVariable, bool) {
+	if a.Data == nil || !isStruct(a.Type, 0) || equateType(a.Type, tStruct) || isInterface(a.Type, 0) {
+		return nil, false
+	}
+
+	args := []*TVariable{}
+	if b != nil {
+		args = append(args, b)
+	}
+
+	blk, pth := findOperator(a.Type, op, args)
+	if blk == nil {
+		return nil, false
+	}
+
+	self := &TVariable{a.Type, convertValPS(a.Type, 0, a.Data)}
+	params := []TVariable{{self.Type, &(self.Data)}}
+	if b != nil {
+		params = append(params, *b)
+	}
+
+	ocrt := cart
+	cart = pth
+	out := evalBlock(*blk, params, true)
+	cart = ocrt
+
+	if !hasReturn(*blk) {
+		return self, true
+	}
+	return &out, true
+}
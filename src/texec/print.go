@@ -0,0 +1,431 @@
+/*
+   Copyright 2020 Kyle Gunger
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package texec
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"tparse"
+)
+
+/**
+	print.go - write a TModule as JSON, an s-expression, or a Graphviz graph, and
+	read one back from JSON.  The JSON schema is described in the README under
+	"Output formats".
+*/
+
+type jsonType struct {
+	Pre  []string `json:"pre"`
+	Path []string `json:"path"`
+	Name string   `json:"name"`
+	Post string   `json:"post"`
+	Len  []int    `json:"len,omitempty"`
+}
+
+type jsonVar struct {
+	Type  jsonType     `json:"type"`
+	Data  interface{}  `json:"data"`
+	// Module variables given their values before main runs
+	Init  *tparse.Node `json:"init,omitempty"`
+	Const bool         `json:"const,omitempty"`
+}
+
+type jsonModule struct {
+	Name      string             `json:"name"`
+	Artifacts []tparse.Node      `json:"artifacts"`
+	Defs      map[string]jsonVar `json:"defs"`
+	Sub       []jsonModule       `json:"sub"`
+}
+
+func toJSONType(t TType) jsonType {
+	out := jsonType{Pre: t.Pre, Path: t.T.Path, Name: t.T.Name, Post: t.Post}
+	if out.Pre == nil {
+		out.Pre = []string{}
+	}
+	if out.Path == nil {
+		out.Path = []string{}
+	}
+	for _, l := range t.Len {
+		if l != 0 {
+			out.Len = t.Len
+		}
+	}
+	return out
+}
+
+func toJSONVar(v TVariable) jsonVar {
+	return jsonVar{Type: toJSONType(v.Type), Data: toJSONValue(v.Data)}
+}
+
+// A module variable or enum member, with the definition of its value if it has not been
+// given one yet, and if it is const
+func toJSONDef(v *TVariable) jsonVar {
+	out := toJSONVar(*v)
+	if in, prs := inits[v]; prs {
+		out.Init, out.Const = &(in.Def), in.Const
+	} else {
+		out.Const = constData[&(v.Data)]
+	}
+	return out
+}
+
+// Values are written as JSON values, except for struct fields, enum members and pointers
+// which are wrapped in objects to tell them apart.
+func toJSONValue(d interface{}) interface{} {
+	switch v := d.(type) {
+	case nil, float64, string, bool:
+		return v
+	case float32:
+		return float64(v)
+	case []interface{}:
+		out := []interface{}{}
+		for _, e := range v {
+			out = append(out, toJSONValue(e))
+		}
+		return out
+	case []TVariable:
+		out := []jsonVar{}
+		for _, e := range v {
+			out = append(out, toJSONVar(e))
+		}
+		return map[string]interface{}{"fields": out}
+	case VarMap:
+		out := map[string]jsonVar{}
+		for k, e := range v {
+			out[k] = toJSONDef(e)
+		}
+		return map[string]interface{}{"members": out}
+	case *interface{}:
+		return map[string]interface{}{"pointer": toJSONValue(*v)}
+	}
+	if bits, signed, ok := intValue(d); ok {
+		if signed {
+			return int64(bits)
+		}
+		return bits
+	}
+	return map[string]interface{}{"unknown": fmt.Sprint(d)}
+}
+
+func toJSONModule(m TModule) jsonModule {
+	out := jsonModule{m.Name, m.Artifacts, map[string]jsonVar{}, []jsonModule{}}
+	if out.Artifacts == nil {
+		out.Artifacts = []tparse.Node{}
+	}
+
+	for k, v := range m.Defs {
+		out.Defs[k] = toJSONDef(v)
+	}
+
+	for _, s := range m.Sub {
+		out.Sub = append(out.Sub, toJSONModule(s))
+	}
+
+	return out
+}
+
+// ModuleJSON converts a module to JSON for use in a tparse.JSONFile
+func ModuleJSON(m TModule) (json.RawMessage, error) {
+	return json.Marshal(toJSONModule(m))
+}
+
+// The same as jsonVar and jsonModule, but values are kept as JSON until their type is known
+type jsonVarIn struct {
+	Type  jsonType        `json:"type"`
+	Data  json.RawMessage `json:"data"`
+	Init  *tparse.Node    `json:"init"`
+	Const bool            `json:"const"`
+}
+
+type jsonModuleIn struct {
+	Name      string               `json:"name"`
+	Artifacts []tparse.Node        `json:"artifacts"`
+	Defs      map[string]jsonVarIn `json:"defs"`
+	Sub       []jsonModuleIn       `json:"sub"`
+}
+
+// ModuleFromJSON reads a module written by ModuleJSON back from a tparse.JSONFile.  As with
+// BuildRoot, problems with the values of module variables are returned as diagnostics.
+func ModuleFromJSON(f tparse.JSONFile) (TModule, []tparse.Diagnostic, error) {
+	if f.Kind != "module" {
+		return TModule{}, nil, fmt.Errorf("%s holds a %s, not a module", f.File, f.Kind)
+	}
+
+	in := jsonModuleIn{}
+	if err := json.Unmarshal(f.Module, &in); err != nil {
+		return TModule{}, nil, err
+	}
+
+	old_l := loading
+	loading = f.File
+	defer func() { loading = old_l }()
+
+	out, err := fromJSONModule(in)
+	if err != nil {
+		return TModule{}, nil, err
+	}
+	return out, orderInits(&out), nil
+}
+
+func fromJSONModule(m jsonModuleIn) (TModule, error) {
+	out := TModule{m.Name, m.Artifacts, make(VarMap), []TModule{}}
+
+	for k, v := range m.Defs {
+		d, err := fromJSONVar(v)
+		if err != nil {
+			return out, fmt.Errorf("%s: %v", k, err)
+		}
+		out.Defs[k] = d
+	}
+
+	for _, s := range m.Sub {
+		sub, err := fromJSONModule(s)
+		if err != nil {
+			return out, err
+		}
+		out.Sub = append(out.Sub, sub)
+	}
+
+	return out, nil
+}
+
+func fromJSONType(t jsonType) TType {
+	return TType{t.Pre, TArtifact{t.Path, t.Name}, t.Post, t.Len}
+}
+
+func fromJSONVar(v jsonVarIn) (*TVariable, error) {
+	t := fromJSONType(v.Type)
+	d, err := fromJSONValue(v.Data, t)
+	out := &TVariable{t, d}
+
+	if v.Init != nil {
+		deferInit(out, *v.Init, v.Const)
+	} else if v.Const {
+		markConst(&(out.Data))
+	}
+	return out, err
+}
+
+// Values are read using the type of the variable they are in, so numbers get back the
+// go type the interpreter uses for that keytype.
+func fromJSONValue(raw json.RawMessage, t TType) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var d interface{}
+	if err := dec.Decode(&d); err != nil {
+		return nil, err
+	}
+
+	switch v := d.(type) {
+	case json.Number:
+		return fromJSONNumber(v, t)
+	case []interface{}:
+		et := t
+		if isArray(t, 0) {
+			et = stripType(t, 1)
+		}
+
+		items := []json.RawMessage{}
+		json.Unmarshal(raw, &items)
+		out := []interface{}{}
+		for _, e := range items {
+			ev, err := fromJSONValue(e, et)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, ev)
+		}
+		return out, nil
+	case map[string]interface{}:
+		obj := struct {
+			Fields  []jsonVarIn          `json:"fields"`
+			Members map[string]jsonVarIn `json:"members"`
+			Pointer json.RawMessage      `json:"pointer"`
+		}{}
+		json.Unmarshal(raw, &obj)
+
+		switch {
+		case obj.Fields != nil:
+			out := []TVariable{}
+			for _, f := range obj.Fields {
+				fv, err := fromJSONVar(f)
+				if err != nil {
+					return nil, err
+				}
+				out = append(out, *fv)
+			}
+			return out, nil
+		case obj.Members != nil:
+			out := make(VarMap)
+			for k, m := range obj.Members {
+				mv, err := fromJSONVar(m)
+				if err != nil {
+					return nil, err
+				}
+				out[k] = mv
+			}
+			return out, nil
+		case obj.Pointer != nil:
+			et := t
+			if isPointer(t, 0) {
+				et = stripType(t, 1)
+			}
+			p, err := fromJSONValue(obj.Pointer, et)
+			return &p, err
+		}
+		return nil, fmt.Errorf("can not read the value %s", raw)
+	}
+	return d, nil
+}
+
+func fromJSONNumber(n json.Number, t TType) (interface{}, error) {
+	if name, ok := intType(t, 0); ok {
+		if i, err := strconv.ParseInt(n.String(), 10, 64); err == nil {
+			return makeInt(name, uint64(i)), nil
+		}
+		u, err := strconv.ParseUint(n.String(), 10, 64)
+		return makeInt(name, u), err
+	}
+
+	if _, ok := floatType(t, 0); ok {
+		f, err := n.Float64()
+		return convertValPS(t, 0, f), err
+	}
+
+	// Anything else gets the go types the parser gives literals
+	if i, err := n.Int64(); err == nil {
+		return int(i), nil
+	}
+	return n.Float64()
+}
+
+// Names of the definitions in a module, sorted so the output is stable
+func defNames(m TModule) []string {
+	out := []string{}
+	for k := range m.Defs {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// SExprModule writes a module as (module "name" (defs ...) (artifacts ...) (sub ...))
+func SExprModule(m TModule) string {
+	b := strings.Builder{}
+	sexprModule(&b, m, "")
+	b.WriteString("\n")
+	return b.String()
+}
+
+func sexprModule(b *strings.Builder, m TModule, ind string) {
+	fmt.Fprintf(b, "(module %s\n%s  (defs", strconv.Quote(m.Name), ind)
+	for _, k := range defNames(m) {
+		v := m.Defs[k]
+		fmt.Fprintf(b, "\n%s    (def %s %s %s)", ind, strconv.Quote(k), sexprType(v.Type), sexprValue(v.Data))
+	}
+
+	fmt.Fprintf(b, ")\n%s  (artifacts", ind)
+	for _, a := range m.Artifacts {
+		b.WriteString("\n" + ind + "    ")
+		b.WriteString(strings.Replace(strings.TrimSuffix(tparse.SExprNode(a), "\n"), "\n", "\n" + ind + "    ", -1))
+	}
+
+	fmt.Fprintf(b, ")\n%s  (sub", ind)
+	for _, s := range m.Sub {
+		b.WriteString("\n" + ind + "    ")
+		sexprModule(b, s, ind + "    ")
+	}
+	b.WriteString("))")
+}
+
+func sexprType(t TType) string {
+	q := func(s []string) string {
+		out := []string{}
+		for _, e := range s {
+			out = append(out, strconv.Quote(e))
+		}
+		return "(" + strings.Join(out, " ") + ")"
+	}
+	return fmt.Sprintf("(type %s %s %s %s)", q(t.Pre), q(t.T.Path), strconv.Quote(t.T.Name), strconv.Quote(t.Post))
+}
+
+func sexprValue(d interface{}) string {
+	switch v := d.(type) {
+	case nil:
+		return "nil"
+	case string:
+		return strconv.Quote(v)
+	case []interface{}:
+		out := []string{}
+		for _, e := range v {
+			out = append(out, sexprValue(e))
+		}
+		return "(list " + strings.Join(out, " ") + ")"
+	case []TVariable:
+		out := []string{}
+		for _, e := range v {
+			out = append(out, fmt.Sprintf("(field %s %s)", sexprType(e.Type), sexprValue(e.Data)))
+		}
+		return "(fields " + strings.Join(out, " ") + ")"
+	case VarMap:
+		out := []string{}
+		for _, k := range defNames(TModule{Defs: v}) {
+			out = append(out, fmt.Sprintf("(member %s %s %s)", strconv.Quote(k), sexprType(v[k].Type), sexprValue(v[k].Data)))
+		}
+		return "(members " + strings.Join(out, " ") + ")"
+	case *interface{}:
+		return "(pointer " + sexprValue(*v) + ")"
+	}
+	return fmt.Sprint(d)
+}
+
+// DotModule writes a module, its definitions, sub-modules and artifacts as a Graphviz graph
+func DotModule(m TModule) string {
+	b := strings.Builder{}
+	fmt.Fprintf(&b, "digraph %s {\n", tparse.DotQuote(m.Name))
+	mid, nid := 0, 0
+	dotModule(&b, m, &mid, &nid)
+	b.WriteString("}\n")
+	return b.String()
+}
+
+func dotModule(b *strings.Builder, m TModule, mid, nid *int) string {
+	me := fmt.Sprintf("m%d", *mid)
+	*mid++
+
+	fmt.Fprintf(b, "\t%s [shape=box, label=%s];\n", me, tparse.DotQuote("module\n" + m.Name))
+
+	for i, k := range defNames(m) {
+		v := m.Defs[k]
+		fmt.Fprintf(b, "\t%s_d%d [shape=note, label=%s];\n", me, i, tparse.DotQuote(k + "\n" + fmt.Sprint(v.Type)))
+		fmt.Fprintf(b, "\t%s -> %s_d%d;\n", me, me, i)
+	}
+
+	for _, a := range m.Artifacts {
+		fmt.Fprintf(b, "\t%s -> %s;\n", me, tparse.DotSubgraph(b, a, "n", nid))
+	}
+
+	for _, s := range m.Sub {
+		fmt.Fprintf(b, "\t%s -> %s;\n", me, dotModule(b, s, mid, nid))
+	}
+
+	return me
+}
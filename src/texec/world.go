@@ -16,6 +16,7 @@
 
 package texec
 
+import "fmt"
 import "tparse"
 
 // TArtifact represents the path to a specific named object in the node tree.
@@ -29,6 +30,13 @@ type TType struct {
 	Pre  []string
 	T    TArtifact
 	Post string
+	// Length of each array in Pre with a fixed length (0 for the rest).  Not used when comparing types.
+	Len  []int
+}
+
+// String prints a type without Len, so printed types do not depend on the lengths of arrays
+func (t TType) String() string {
+	return fmt.Sprintf("{%v %v %v}", t.Pre, t.T, t.Post)
 }
 
 // TVariable represents a single variable in the program
@@ -39,6 +47,28 @@ type TVariable struct {
 
 type VarMap map[string]*TVariable
 
+// Scope holds the variables defined in a block.  Variables not found in it are looked
+// up in the scope of the block around it.
+type Scope struct {
+	Vars   VarMap
+	Parent *Scope
+}
+
+// Make the scope for a block inside the given one (nil for a function body)
+func newScope(parent *Scope) *Scope {
+	return &Scope{make(VarMap), parent}
+}
+
+// Find a variable in the scope or the ones around it
+func (s *Scope) get(name string) (*TVariable, bool) {
+	for ; s != nil; s = s.Parent {
+		if v, prs := s.Vars[name]; prs {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
 // TModule represents a collection of files and sub-modules in a program
 type TModule struct {
 	Name       string
@@ -19,10 +19,14 @@ package texec
 import (
 	"tparse"
 	"fmt"
+	"os"
 )
 
 var (
 	Quiet = false
+
+	// The file being imported
+	loading string
 )
 
 /**
@@ -52,31 +56,72 @@ func evalPreLiteral(n tparse.Node) string {
 
 
 func modDef(n tparse.Node, m *TModule) {
-	t := getType(n.Sub[0])
-	s, vs := modDefVars(n.Sub[1], t)
+	t, isConst := splitConst(getType(n.Sub[0]))
+	s, vs, later := modDefVars(n.Sub[1], t)
 	for i := 0; i < len(s); i++ {
 		m.Defs[s[i]] = &(vs[i])
+		if later[i] != nil {
+			deferInit(&(vs[i]), *later[i], isConst)
+		} else if isConst {
+			markConst(&(vs[i].Data))
+		}
+	}
+}
+
+// The data of const variables, which can not be assigned to
+var constData = map[*interface{}]bool{}
+
+// Take const off the front of a type, telling if it was there
+func splitConst(t TType) (TType, bool) {
+	if len(t.Pre) > 0 && t.Pre[0] == "const" {
+		return stripType(t, 1), true
+	}
+	return t, false
+}
+
+// Mark a variable's data (and any members or elements in it) as const
+func markConst(d *interface{}) {
+	constData[d] = true
+	switch v := (*d).(type) {
+	case VarMap:
+		for _, e := range v {
+			markConst(&(e.Data))
+		}
+	case []interface{}:
+		for i := range v {
+			markConst(&(v[i]))
+		}
 	}
 }
 
 // Generate a variable list for a module
 // For sub = 0, give the vlist
-// May be horribly broken.  Definitely doesn't support composite types.
-func modDefVars(n tparse.Node, t TType) ([]string, []TVariable) {
+// Values which are not a single literal, and zero values (which may need structs from
+// anywhere in the program), are set before main runs (see init.go).  For those the
+// = node or name is given in the third list.
+func modDefVars(n tparse.Node, t TType) ([]string, []TVariable, []*tparse.Node) {
 	s := []string{}
 	v := []TVariable{}
+	later := []*tparse.Node{}
 	for i := 0; i < len(n.Sub); i++ {
 		if n.Sub[i].Data.Type == tparse.DEFWORD {
 			s = append(s, n.Sub[i].Data.Data)
 			v = append(v, TVariable{t, nil})
+			later = append(later, &(n.Sub[i]))
 		} else if n.Sub[i].Data.Data == "=" && n.Sub[i].Sub[0].Data.Type == tparse.DEFWORD {
 			s = append(s, n.Sub[i].Sub[0].Data.Data)
-			v = append(v, TVariable{t, getLiteral(n.Sub[i].Sub[1], t)})
+			if isLiteral(n.Sub[i].Sub[1]) {
+				v = append(v, TVariable{t, getLiteral(n.Sub[i].Sub[1], t)})
+				later = append(later, nil)
+			} else {
+				v = append(v, TVariable{t, nil})
+				later = append(later, &(n.Sub[i]))
+			}
 		} else {
 			errOut(fmt.Sprintf("Unexpected thing in definition. Expected '=' or DEFWORD. %v", n.Sub[i].Data))
 		}
 	}
-	return s, v
+	return s, v, later
 }
 
 func modDefStruct(n tparse.Node, m *TModule) {
@@ -86,6 +131,9 @@ func modDefStruct(n tparse.Node, m *TModule) {
 	for i := 0; i < len(n.Sub); i++ {
 		if n.Sub[i].Data.Type == tparse.DEFWORD {
 			name = n.Sub[i].Data.Data
+		} else if n.Sub[i].Data.Data == "extends" {
+			// The parent is kept as a member named super (which can't be a real member's name)
+			tvlist = append([]TVariable{{getType(n.Sub[i].Sub[0]), "super"}}, tvlist...)
 		} else if n.Sub[i].Data.Data == "plist" && n.Sub[i].Data.Type == 10 {
 			var t TType
 			for j := 0; j < len(n.Sub[i].Sub); j++ {
@@ -109,56 +157,88 @@ func modDefEnum(n tparse.Node, m *TModule) {
 			fmt.Println(t)
 	}
 	
-	s, vs := modDefVars(n.Sub[2], t)
+	s, vs, later := modDefVars(n.Sub[2], t)
 	out := TVariable{tEnum, make(VarMap)}
 	for i := 0; i < len(s); i++ {
 		out.Data.(VarMap)[s[i]] = &(vs[i])
+		if later[i] != nil {
+			deferInit(&(vs[i]), *later[i], true)
+		}
 	}
 	m.Defs[name] = &(out)
 }
 
 // Parse a file and make an AST from it.
-func parseFile(p string) tparse.Node {
-	tokens := tparse.TokenizeFile(p)
-	return tparse.MakeTree(&(tokens), p)
+func parseFile(p string) (tparse.Node, []tparse.Diagnostic, error) {
+	fd, err := os.Open(p)
+	if err != nil {
+		return tparse.Node{}, nil, err
+	}
+	defer fd.Close()
+
+	return tparse.Parse(fd, p)
+}
+
+// Add a node from the top of a file or module block to the module: sub-modules, included
+// files, and definitions are loaded, anything else is kept as an artifact.
+func loadNode(n tparse.Node, m *TModule) ([]tparse.Diagnostic, error) {
+	if n.Data.Data == "block" {
+		if n.Sub[0].Sub[0].Data.Data == "module" || n.Sub[0].Sub[0].Data.Data == "export" {
+			sub, diags, err := buildModule(n)
+			m.Sub = append(m.Sub, sub)
+			return diags, err
+		}
+		m.Artifacts = append(m.Artifacts, n)
+	} else if n.Data.Data == "include" {
+		if !Quiet {
+			fmt.Printf("[INCLUDE] %s\n", evalPreLiteral(n.Sub[0]))
+		}
+		return importFile(evalPreLiteral(n.Sub[0]), m)
+	} else if n.Data.Data == "define" {
+		modDef(n, m)
+	} else if n.Data.Data == "enum" {
+		modDefEnum(n, m)
+	} else if n.Data.Data == "struct" || n.Data.Data == "raw" {
+		modDefStruct(n, m)
+	} else {
+		m.Artifacts = append(m.Artifacts, n)
+	}
+	return nil, nil
 }
 
 // Import a file and auto-import sub-modules and files
-func importFile(f string, m *TModule) {
+// Returns the syntax errors (and problems found by checkFile) in the file and the files it imports
+func importFile(f string, m *TModule) ([]tparse.Diagnostic, error) {
 	if !Quiet {
 		fmt.Printf("[INFO] Importing file %s\n", f)
 	}
-	froot := parseFile(f)
+	froot, diags, err := parseFile(f)
+	if err != nil || len(diags) > 0 {
+		return diags, err
+	}
+	diags = checkFile(&froot, f)
+
+	ofile := loading
+	loading = f
+	defer func() { loading = ofile }()
+
 	for n := 0 ; n < len(froot.Sub) ; n++ {
-		if froot.Sub[n].Data.Data == "block" {
-			if froot.Sub[n].Sub[0].Sub[0].Data.Data == "module" || froot.Sub[n].Sub[0].Sub[0].Data.Data == "export" {
-				m.Sub = append(m.Sub, buildModule(froot.Sub[n]))
-			} else {
-				m.Artifacts = append(m.Artifacts, froot.Sub[n])
-			}
-		} else if froot.Sub[n].Data.Data == "include" {
-			if !Quiet {
-				fmt.Printf("[INCLUDE] %s\n", evalPreLiteral(froot.Sub[n].Sub[0]))
-			}
-			importFile(evalPreLiteral(froot.Sub[n].Sub[0]), m)
-		} else if froot.Sub[n].Data.Data == "define" {
-			modDef(froot.Sub[n], m)
-		} else if froot.Sub[n].Data.Data == "enum"{
-			modDefEnum(froot.Sub[n], m)
-		} else if froot.Sub[n].Data.Data == "struct" || froot.Sub[n].Data.Data == "raw"{
-			modDefStruct(froot.Sub[n], m)
-		} else {
-			m.Artifacts = append(m.Artifacts, froot.Sub[n])
+		d, err := loadNode(froot.Sub[n], m)
+		diags = append(diags, d...)
+		if err != nil {
+			return diags, err
 		}
-		
 	}
 	if !Quiet {
 		fmt.Printf("[INFO] File %s has been imported.\n", f)
 	}
+
+	return diags, nil
 }
 
 // Build a module from a module block node
-func buildModule(module tparse.Node) TModule {
+func buildModule(module tparse.Node) (TModule, []tparse.Diagnostic, error) {
+	var diags []tparse.Diagnostic
 	out := TModule{}
 	out.Defs = make(VarMap)
 	if module.Sub[0].Sub[0].Data.Data == "export" {
@@ -172,11 +252,10 @@ func buildModule(module tparse.Node) TModule {
 	}
 
 	for n := 1 ; n < len(module.Sub) ; n++ {
-		if module.Sub[n].Data.Data == "include" {
-			if !Quiet {
-				fmt.Printf("[INCLUDE] %s\n", evalPreLiteral(module.Sub[n].Sub[0]))
-			}
-			importFile(evalPreLiteral(module.Sub[n].Sub[0]), &out)
+		d, err := loadNode(module.Sub[n], &out)
+		diags = append(diags, d...)
+		if err != nil {
+			return out, diags, err
 		}
 	}
 
@@ -184,15 +263,19 @@ func buildModule(module tparse.Node) TModule {
 		fmt.Printf("[INFO] Finished loading module %s\n", out.Name)
 	}
 
-	return out
+	return out, diags, nil
 }
 
 // BuildRoot builds the root module, ready for eval
-func BuildRoot(file string) TModule {
+// Syntax errors in any imported file are returned as diagnostics, the error is set if a file could not be read.
+func BuildRoot(file string) (TModule, []tparse.Diagnostic, error) {
 	out := TModule{}
 	out.Defs = make(VarMap)
 
-	importFile(file, &out)
+	diags, err := importFile(file, &out)
+	if err == nil && !tparse.HasErrors(diags) {
+		diags = append(diags, orderInits(&out)...)
+	}
 
-	return out
+	return out, diags, err
 }
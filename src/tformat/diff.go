@@ -0,0 +1,169 @@
+/*
+   Copyright 2020 Kyle Gunger
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package tformat
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Lines of context around each change
+const CONTEXT = 3
+
+// A line in a diff (' ' for both files, '-' for only a, '+' for only b)
+type edit struct {
+	op   byte
+	text string
+}
+
+// Diff makes a unified diff from a to b.  The result is empty if they are the same.
+func Diff(name string, a, b []byte) string {
+	if string(a) == string(b) {
+		return ""
+	}
+
+	edits := diffLines(splitLines(string(a)), splitLines(string(b)))
+
+	out := strings.Builder{}
+	fmt.Fprintf(&out, "--- %s.orig\n+++ %s\n", name, name)
+
+	// Line numbers (starting at 1) in a and b of the next edit
+	la, lb := 1, 1
+	for i := 0; i < len(edits); {
+		if edits[i].op == ' ' {
+			la, lb = la + 1, lb + 1
+			i++
+			continue
+		}
+
+		// Hunk from CONTEXT lines before the change to CONTEXT lines after the last
+		// change closer than 2 * CONTEXT lines to the one before it
+		start := i - CONTEXT
+		if start < 0 {
+			start = 0
+		}
+
+		end := i
+		for same := 0; end < len(edits) && same <= 2 * CONTEXT; end++ {
+			if edits[end].op == ' ' {
+				same++
+			} else {
+				same = 0
+			}
+		}
+
+		for end > i && edits[end - 1].op == ' ' {
+			end--
+		}
+		end += CONTEXT
+		if end > len(edits) {
+			end = len(edits)
+		}
+
+		sa, sb := la - (i - start), lb - (i - start)
+		ca, cb := 0, 0
+		hunk := strings.Builder{}
+		for _, e := range edits[start:end] {
+			hunk.WriteByte(e.op)
+			hunk.WriteString(e.text)
+			hunk.WriteByte('\n')
+			if e.op != '+' {
+				ca++
+			}
+			if e.op != '-' {
+				cb++
+			}
+		}
+
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n%s", hunkRange(sa, ca), hunkRange(sb, cb), hunk.String())
+
+		for _, e := range edits[i:end] {
+			if e.op != '+' {
+				la++
+			}
+			if e.op != '-' {
+				lb++
+			}
+		}
+		i = end
+	}
+
+	return out.String()
+}
+
+func hunkRange(start, count int) string {
+	if count == 0 {
+		start--
+	}
+	if count == 1 {
+		return fmt.Sprint(start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+func splitLines(s string) []string {
+	s = strings.TrimSuffix(s, "\n")
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(s, "\n")
+}
+
+// Line by line diff using the longest common subsequence
+func diffLines(a, b []string) []edit {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a) + 1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b) + 1)
+	}
+
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i + 1][j + 1] + 1
+			} else if lcs[i + 1][j] >= lcs[i][j + 1] {
+				lcs[i][j] = lcs[i + 1][j]
+			} else {
+				lcs[i][j] = lcs[i][j + 1]
+			}
+		}
+	}
+
+	out := []edit{}
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		if a[i] == b[j] {
+			out = append(out, edit{' ', a[i]})
+			i, j = i + 1, j + 1
+		} else if lcs[i + 1][j] >= lcs[i][j + 1] {
+			out = append(out, edit{'-', a[i]})
+			i++
+		} else {
+			out = append(out, edit{'+', b[j]})
+			j++
+		}
+	}
+
+	for ; i < len(a); i++ {
+		out = append(out, edit{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		out = append(out, edit{'+', b[j]})
+	}
+
+	return out
+}
@@ -0,0 +1,439 @@
+/*
+   Copyright 2020 Kyle Gunger
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package tformat lays out TNSL source in one canonical style.
+//
+// Only whitespace is changed.  Each statement gets its own line, indented with one
+// tab per open block, and the block delimiters (;/ ;; and friends) sit at the
+// indentation of the block they close.  Binary operators get a space on each side,
+// unary operators, "." and delimiters do not.  Comments are kept where they were, and
+// up to one blank line is kept between lines.
+package tformat
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"tparse"
+)
+
+// A token or comment to print
+type piece struct {
+	tok  *tparse.Token
+	text string
+	// Line comment (must end its line)
+	line bool
+	// Body of a comment switch (;# ... #;), printed as it was
+	inner bool
+	// Line breaks before the piece in the original source
+	nl int
+}
+
+// What a printed token was used as, for deciding the spacing after it
+const (
+	roleWord = iota
+	roleKeyword
+	roleOperatorKw
+	roleLine
+	roleBlock
+	roleOpen
+	roleClose
+	roleComma
+	roleDot
+	roleBinary
+	rolePrefix
+	rolePostfix
+	roleComment
+)
+
+// Source formats a TNSL file.  Files with syntax errors are not formatted, the
+// first error is returned instead.
+func Source(src []byte, filename string) ([]byte, error) {
+	_, diags, err := tparse.Parse(bytes.NewReader(src), filename)
+	if err != nil {
+		return nil, err
+	} else if len(diags) > 0 {
+		return nil, diags[0]
+	}
+
+	toks, _, err := tparse.TokenizeTrivia(bytes.NewReader(src), filename)
+	if err != nil {
+		return nil, err
+	}
+
+	p := printer{}
+	for _, pc := range pieces(toks) {
+		if pc.tok == nil {
+			p.comment(pc)
+		} else {
+			p.token(pc)
+		}
+	}
+
+	// A comment left open at the end of the file would swallow the line break
+	if p.b.Len() > 0 && !p.openComment {
+		p.b.WriteString("\n")
+	}
+	out := []byte(p.b.String())
+
+	if !same(src, out, filename) {
+		return nil, errors.New(filename + ": [Internal] formatting changed the meaning of the file")
+	}
+
+	return out, nil
+}
+
+// Flatten the tokens and their trivia into a list of pieces
+func pieces(toks []tparse.Token) []piece {
+	out := []piece{}
+	nl := 0
+
+	add := func(tv []tparse.Trivia) {
+		for _, t := range tv {
+			if t.Type == tparse.WHITESPACE {
+				nl += strings.Count(t.Data, "\n")
+				continue
+			}
+
+			pc := piece{text: t.Data, line: t.Type == tparse.LINECOMMENT, nl: nl}
+			if l := len(out); l > 0 && out[l - 1].tok != nil && t.Type == tparse.BLOCKCOMMENT {
+				raw := out[l - 1].tok.Raw
+				pc.inner = raw == ";#" || raw == ":#"
+			}
+			out = append(out, pc)
+			nl = 0
+		}
+	}
+
+	for i := range toks {
+		add(toks[i].Leading)
+		if toks[i].Type != -1 {
+			out = append(out, piece{tok: &(toks[i]), nl: nl})
+			nl = 0
+		}
+		add(toks[i].Trailing)
+	}
+
+	return out
+}
+
+// Block delimiters: do they close a block, and what kind of block (if any) do they open
+var blockDelims = map[string]struct {
+	close bool
+	open  string
+}{
+	"/;": {false, "code"},
+	";/": {true, ""},
+	";;": {true, "code"},
+	"/:": {false, "pre"},
+	":/": {true, ""},
+	"::": {true, "pre"},
+	":;": {true, "code"},
+	";:": {true, "pre"},
+}
+
+type printer struct {
+	b strings.Builder
+
+	// Open blocks ("code" or "pre"), and open ( [ { in the current line
+	blocks []string
+	paren  int
+
+	// In a block definition, or a struct or enum statement
+	header, decl bool
+
+	// The last token printed and what it was used as
+	prev     string
+	prevRole int
+
+	// The next piece must start a new line
+	forceBreak bool
+
+	// The last piece was a block comment which is still open
+	openComment bool
+}
+
+func (p *printer) inPre() bool {
+	return len(p.blocks) > 0 && p.blocks[len(p.blocks) - 1] == "pre"
+}
+
+// Start a new line (keeping a blank line if there was one) and indent it
+func (p *printer) newline(nl, indent int) {
+	if p.b.Len() > 0 {
+		p.b.WriteString("\n")
+		if nl > 1 {
+			p.b.WriteString("\n")
+		}
+	}
+	p.b.WriteString(strings.Repeat("\t", indent))
+}
+
+// Indentation for a line continuing a statement
+func (p *printer) contIndent() int {
+	if p.inPre() {
+		return len(p.blocks)
+	}
+	return len(p.blocks) + 1
+}
+
+func (p *printer) comment(pc piece) {
+	if pc.inner {
+		p.b.WriteString(pc.text)
+		p.openComment = true
+		p.prevRole = roleComment
+		return
+	}
+
+	if p.b.Len() > 0 {
+		if pc.nl > 0 || p.forceBreak {
+			indent := len(p.blocks)
+			if p.paren > 0 {
+				indent = p.contIndent()
+			}
+			p.newline(pc.nl, indent)
+		} else {
+			p.b.WriteString(" ")
+		}
+	}
+
+	if pc.line {
+		p.b.WriteString(strings.TrimRight(pc.text, " \t\r"))
+	} else {
+		p.b.WriteString(pc.text)
+	}
+
+	p.forceBreak = pc.line
+	p.openComment = !pc.line && !strings.HasSuffix(pc.text, "#/")
+	p.prevRole = roleComment
+}
+
+func (p *printer) token(pc piece) {
+	t := pc.tok
+	d := t.Data
+	bd, isBlock := blockDelims[d]
+	brk := p.paren == 0 && (isBlock || d == ";" || d == ":")
+
+	role := p.role(t)
+
+	// Anything put between a block comment and the delimiter which ends it
+	// would become part of the comment
+	closing := p.openComment && t.Raw != ""
+	p.openComment = false
+
+	if closing {
+		if bd.close && len(p.blocks) > 0 {
+			p.blocks = p.blocks[:len(p.blocks) - 1]
+		}
+	} else if brk {
+		if bd.close && len(p.blocks) > 0 {
+			p.blocks = p.blocks[:len(p.blocks) - 1]
+		}
+		p.newline(pc.nl, len(p.blocks))
+	} else if p.forceBreak || (p.inPre() && p.paren == 0 && pc.nl > 0) {
+		p.newline(pc.nl, p.contIndent())
+	} else {
+		p.b.WriteString(p.space(t, role))
+	}
+
+	p.b.WriteString(t.Text())
+	p.forceBreak = false
+
+	if isBlock && (brk || closing) {
+		if bd.open != "" {
+			p.blocks = append(p.blocks, bd.open)
+			role = roleBlock
+		} else {
+			role = roleLine
+		}
+		p.header = bd.open == "code"
+		p.decl = false
+	} else if brk {
+		p.header, p.decl = false, false
+		role = roleLine
+	} else if p.prevRole == roleLine && (d == "struct" || d == "enum" || d == "raw") {
+		p.decl = true
+	}
+
+	switch d {
+	case "(", "[", "{":
+		p.paren++
+	case ")", "]", "}":
+		if p.paren > 0 {
+			p.paren--
+		}
+	}
+
+	p.prev, p.prevRole = d, role
+}
+
+// Is the last thing printed the end of a value
+func (p *printer) valueEnd() bool {
+	return p.prevRole == roleWord || p.prevRole == roleClose || p.prevRole == rolePostfix
+}
+
+// Work out what a token is used as
+func (p *printer) role(t *tparse.Token) int {
+	d := t.Data
+
+	switch d {
+	case ",":
+		return roleComma
+	case "(", "[", "{":
+		return roleOpen
+	case ")", "]", "}":
+		return roleClose
+	case ".":
+		return roleDot
+	case ";", ":":
+		// Only inside a list of statements
+		return roleComma
+	}
+
+	switch t.Type {
+	case tparse.AUGMENT:
+		if p.prevRole == roleOperatorKw {
+			// The operator an operator block overloads
+			return roleWord
+		}
+
+		switch d {
+		case "`":
+			return rolePostfix
+		case "++", "--":
+			if p.valueEnd() {
+				return rolePostfix
+			}
+			return rolePrefix
+		case "-":
+			if p.valueEnd() {
+				return roleBinary
+			}
+			return rolePrefix
+		}
+
+		if _, prs := tparse.ORDER[d]; prs || isAssign(d) {
+			return roleBinary
+		}
+		return rolePrefix
+	case tparse.KEYWORD:
+		if d == "operator" {
+			return roleOperatorKw
+		}
+		return roleKeyword
+	}
+
+	return roleWord
+}
+
+// Is the operator an assignment like += or ~=
+func isAssign(op string) bool {
+	switch op {
+	case "==", "!==", ">==", "<==":
+		return false
+	}
+	return len(op) > 1 && strings.HasSuffix(op, "=")
+}
+
+// The space to put between the last token and the next one
+func (p *printer) space(t *tparse.Token, role int) string {
+	prev := p.prevRole
+	sp := " "
+
+	switch {
+	case prev == roleLine:
+		sp = ""
+	case prev == roleBlock || prev == roleComment:
+		sp = " "
+	case role == roleBinary || prev == roleBinary:
+		sp = " "
+	case role == roleComma:
+		sp = ""
+	case prev == roleComma:
+		sp = " "
+	case role == roleDot || prev == roleDot:
+		sp = ""
+	case role == rolePostfix:
+		sp = ""
+	case prev == rolePrefix:
+		if p.prev != "len" {
+			sp = ""
+		}
+	case role == roleClose || prev == roleOpen:
+		sp = ""
+	case role == roleOpen:
+		if prev != roleKeyword && !((p.header || p.decl) && p.paren == 0) {
+			sp = ""
+		}
+	case prev == roleClose:
+		if p.prev == "}" {
+			sp = ""
+		}
+	}
+
+	// Keep reserved runes from joining into a different rune group
+	if sp == "" && merges(p.prev, t.Text()) {
+		sp = " "
+	}
+
+	return sp
+}
+
+// Would printing a and b next to each other make a different rune group
+func merges(a, b string) bool {
+	for i := 1; i <= len(b); i++ {
+		if _, prs := tparse.RESRUNES[a + b[:i]]; prs {
+			return true
+		}
+	}
+	return false
+}
+
+// Check that formatting only changed whitespace
+func same(a, b []byte, filename string) bool {
+	ta, _, erra := tparse.TokenizeTrivia(bytes.NewReader(a), filename)
+	tb, _, errb := tparse.TokenizeTrivia(bytes.NewReader(b), filename)
+	if erra != nil || errb != nil {
+		return false
+	}
+
+	sa, sb := summary(ta), summary(tb)
+	if len(sa) != len(sb) {
+		return false
+	}
+
+	for i := range sa {
+		if sa[i] != sb[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Tokens and comments of a file, without whitespace
+func summary(toks []tparse.Token) []string {
+	out := []string{}
+	for _, pc := range pieces(toks) {
+		if pc.tok != nil {
+			out = append(out, pc.tok.Text())
+		} else if pc.line {
+			out = append(out, strings.TrimRight(pc.text, " \t\r"))
+		} else {
+			out = append(out, pc.text)
+		}
+	}
+	return out
+}
@@ -18,17 +18,77 @@ package main
 
 import "fmt"
 import "texec"
+import "tparse"
 import "flag"
+import "os"
 
 func main() {
 	inputFile := flag.String("in", "", "The file to execute")
 	progFlags := flag.String("flags", "", "Flags for the executing program")
 	quietFlag := flag.Bool("quiet", false, "Quiet the interpreter when importing files")
+	heapFlag := flag.Bool("checkheap", false, "Report use after free, double free, and memory leaks")
+	jsonFlag := flag.Bool("json", false, "Execute a module written by parse with -writelevel 2 -format json")
 
 	flag.Parse()
 
+	// Errors in the program have already been written out, so leave out Go's trace
+	defer func() {
+		if r := recover(); r != nil {
+			if !texec.IsEvalError(r) {
+				panic(r)
+			}
+			os.Exit(1)
+		}
+	}()
+
 	texec.Quiet = *quietFlag
-	root := texec.BuildRoot(*inputFile)
+	texec.CheckHeap = *heapFlag
+	var root texec.TModule
+	var diags []tparse.Diagnostic
+	var err error
+
+	if *jsonFlag {
+		root, diags, err = readModule(*inputFile)
+	} else {
+		root, diags, err = texec.BuildRoot(*inputFile)
+	}
+
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	for _, d := range diags {
+		fmt.Fprintln(os.Stderr, d.Error())
+	}
+
+	if tparse.HasErrors(diags) {
+		os.Exit(1)
+	}
 
 	fmt.Printf("Program end.  Returned %v.\n", texec.EvalTNSL(&root, *progFlags))
-}
\ No newline at end of file
+
+	leaks := texec.HeapLeaks()
+	for _, l := range leaks {
+		fmt.Fprintln(os.Stderr, l)
+	}
+
+	if len(leaks) > 0 {
+		os.Exit(1)
+	}
+}
+
+// Load a module from a JSON file written by parse
+func readModule(file string) (texec.TModule, []tparse.Diagnostic, error) {
+	fd, err := os.Open(file)
+	if err != nil {
+		return texec.TModule{}, nil, err
+	}
+	defer fd.Close()
+
+	jf, err := tparse.ReadJSON(fd)
+	if err != nil {
+		return texec.TModule{}, nil, err
+	}
+	return texec.ModuleFromJSON(jf)
+}
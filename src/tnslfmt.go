@@ -0,0 +1,131 @@
+/*
+   Copyright 2020 Kyle Gunger
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import "fmt"
+import "tformat"
+import "flag"
+import "io/ioutil"
+import "os"
+import "path/filepath"
+import "strings"
+
+var (
+	write = flag.Bool("w", false, "Write the result back to the file instead of printing it")
+	diff  = flag.Bool("d", false, "Print a diff of the changes instead of the result")
+	list  = flag.Bool("l", false, "List the files which are not formatted")
+
+	failed = false
+)
+
+func report(err error) {
+	fmt.Fprintln(os.Stderr, err.Error())
+	failed = true
+}
+
+// Format one file (or standard input if name is empty)
+func formatFile(name string) {
+	var src []byte
+	var err error
+
+	if name == "" {
+		src, err = ioutil.ReadAll(os.Stdin)
+		name = "<standard input>"
+	} else {
+		src, err = ioutil.ReadFile(name)
+	}
+
+	if err != nil {
+		report(err)
+		return
+	}
+
+	out, err := tformat.Source(src, name)
+	if err != nil {
+		report(err)
+		return
+	}
+
+	changed := string(src) != string(out)
+
+	if *list && changed {
+		fmt.Println(name)
+	}
+
+	if *write && changed && name != "<standard input>" {
+		info, err := os.Stat(name)
+		if err == nil {
+			err = ioutil.WriteFile(name, out, info.Mode())
+		}
+		if err != nil {
+			report(err)
+		}
+	}
+
+	if *diff {
+		fmt.Print(tformat.Diff(name, src, out))
+	}
+
+	if !*list && !*write && !*diff {
+		os.Stdout.Write(out)
+	}
+}
+
+// Format every .tnsl file in a directory
+func formatDir(dir string) {
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			report(err)
+		} else if !info.IsDir() && strings.HasSuffix(path, ".tnsl") {
+			formatFile(path)
+		}
+		return nil
+	})
+
+	if err != nil {
+		report(err)
+	}
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: tnslfmt [flags] [files or directories]")
+		fmt.Fprintln(os.Stderr, "With no files, standard input is formatted.")
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		formatFile("")
+	}
+
+	for _, arg := range flag.Args() {
+		info, err := os.Stat(arg)
+		if err != nil {
+			report(err)
+		} else if info.IsDir() {
+			formatDir(arg)
+		} else {
+			formatFile(arg)
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,316 @@
+/*
+   Copyright 2020 Kyle Gunger
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package ast is a typed form of the tree made by tparse.
+// Each kind of node has its own struct instead of being coded as a tparse.Node
+// with Type 10 and a name like "vlist" or "bdef".  Use FromNode to convert a
+// tree from the old form.
+package ast
+
+import "tparse"
+
+// Node is any node in the tree
+type Node interface {
+	// Position of the first rune of the node
+	Pos() tparse.Pos
+	// Position just after the node
+	End() tparse.Pos
+}
+
+// Expr is a node which makes a value
+type Expr interface {
+	Node
+	exprNode()
+}
+
+// Stmt is a node which can appear in the body of a block or file
+type Stmt interface {
+	Node
+	stmtNode()
+}
+
+// Span is the source range covered by a node
+type Span struct {
+	From, To tparse.Pos
+}
+
+// Pos gives the start of the span
+func (s Span) Pos() tparse.Pos { return s.From }
+
+// End gives the end of the span
+func (s Span) End() tparse.Pos { return s.To }
+
+//###############
+//# Expressions #
+//###############
+
+// Ident is a user defined word (variable, function, struct, etc.)
+type Ident struct {
+	Span
+	Name string
+}
+
+// BasicLit is a literal value
+type BasicLit struct {
+	Span
+	// tparse.INTLIT, tparse.FLOATLIT, etc.  Zero for self and super
+	Kind  int
+	Value string
+}
+
+// CompositeLit is a set of values in braces, like {1, 2, 3}
+type CompositeLit struct {
+	Span
+	Elems []Expr
+}
+
+// BinaryExpr is two values joined by an operator, including "." and "="
+type BinaryExpr struct {
+	Span
+	Op   string
+	X, Y Expr
+}
+
+// UnaryExpr is a value with a prefix operator
+type UnaryExpr struct {
+	Span
+	Op string
+	X  Expr
+}
+
+// PostfixExpr is a value with a postfix operator ("`", "++", or "--")
+type PostfixExpr struct {
+	Span
+	Op string
+	X  Expr
+}
+
+// Call is a function or method call
+type Call struct {
+	Span
+	Fun  Expr
+	Args []Expr
+}
+
+// Index is an array index, like a{1}
+type Index struct {
+	Span
+	X       Expr
+	Indices []Expr
+}
+
+// Cast is a type cast, like a[int]
+type Cast struct {
+	Span
+	X     Expr
+	Types []*TypeExpr
+}
+
+// IsExpr checks the type of a value, like a is int
+type IsExpr struct {
+	Span
+	X    Expr
+	Type *TypeExpr
+}
+
+//#########
+//# Types #
+//#########
+
+// TypeMod is a modifier in front of a type: "~", "{}", "const", "volatile", or "static"
+type TypeMod struct {
+	Span
+	Op string
+	// Length of a fixed size array ("{}" only, nil otherwise)
+	Len []Expr
+}
+
+// TypeExpr is a type, like ~{}int or tnsl.io.File
+type TypeExpr struct {
+	Span
+	Mods []TypeMod
+	// Modules the type is in
+	Path []string
+	Name string
+	// Parameters of a generic or void (function) type, and the return types of a void type
+	Args, Returns []*TypeExpr
+	// "`" for a reference parameter
+	Post string
+}
+
+//##############
+//# Statements #
+//##############
+
+// ExprStmt is a value used as a statement
+type ExprStmt struct {
+	Span
+	X Expr
+}
+
+// Var is one variable in a definition, with the value it is set to (if any)
+type Var struct {
+	Span
+	Name Expr
+	// "=" or a compound assignment like "~=" (empty if there is no value)
+	Op   string
+	Init Expr
+}
+
+// Define is a variable definition, like ;int a = 1, b
+type Define struct {
+	Span
+	Type *TypeExpr
+	Vars []*Var
+}
+
+// Param is a type and the names which share it in a parameter or member list
+type Param struct {
+	Span
+	Type  *TypeExpr
+	Names []Expr
+}
+
+// StructDecl is a struct definition
+type StructDecl struct {
+	Span
+	Raw    bool
+	Name   *Ident
+	Params []Expr
+	// The struct this one extends, if any
+	Extends *TypeExpr
+	Fields  []*Param
+}
+
+// EnumDecl is an enum definition
+type EnumDecl struct {
+	Span
+	Name   *Ident
+	Type   *TypeExpr
+	Values []*Var
+}
+
+// ReturnStmt is a return, with an optional value
+type ReturnStmt struct {
+	Span
+	Value Expr
+}
+
+// BranchStmt is a break or continue, with an optional number of levels
+type BranchStmt struct {
+	Span
+	Keyword string
+	Level   Expr
+}
+
+// LabelStmt marks a place goto can jump to
+type LabelStmt struct {
+	Span
+	Name *Ident
+}
+
+// GotoStmt jumps to a label
+type GotoStmt struct {
+	Span
+	Label *Ident
+}
+
+// AllocStmt is an alloc, salloc, realloc, or delete
+type AllocStmt struct {
+	Span
+	Keyword string
+	Args    []Expr
+}
+
+// KeywordStmt is any other statement made of a lone keyword
+type KeywordStmt struct {
+	Span
+	Keyword string
+}
+
+//##########
+//# Blocks #
+//##########
+
+// BlockDef is the start of a block, like /; method a (int b) [int]
+type BlockDef struct {
+	Span
+	// Keywords like if, loop, export, method, in the order they were written
+	Keywords []string
+	// Name of the block, or the module, method, or interface it defines
+	Name *Ident
+	// Operator overloaded by an operator block
+	Operator string
+	// Parameters and return type of a function or method
+	Params []*Param
+	Return *TypeExpr
+	// Statements in the () and [] of a control flow block (if, loop, match, case)
+	Start, Each []Stmt
+}
+
+// Block is a code block
+type Block struct {
+	Span
+	Def  *BlockDef
+	Body []Stmt
+}
+
+// PreDirective is a pre-processor directive, like :include "file"
+type PreDirective struct {
+	Span
+	Name string
+	Args []tparse.Token
+}
+
+// BadNode stands in for code which failed to parse or convert
+type BadNode struct {
+	Span
+	Message string
+	Tokens  []tparse.Token
+}
+
+// File is the root of the tree for a file
+type File struct {
+	Span
+	Name  string
+	Items []Stmt
+}
+
+func (*Ident) exprNode()        {}
+func (*BasicLit) exprNode()     {}
+func (*CompositeLit) exprNode() {}
+func (*BinaryExpr) exprNode()   {}
+func (*UnaryExpr) exprNode()    {}
+func (*PostfixExpr) exprNode()  {}
+func (*Call) exprNode()         {}
+func (*Index) exprNode()        {}
+func (*Cast) exprNode()         {}
+func (*IsExpr) exprNode()       {}
+func (*BadNode) exprNode()      {}
+
+func (*ExprStmt) stmtNode()     {}
+func (*Define) stmtNode()       {}
+func (*StructDecl) stmtNode()   {}
+func (*EnumDecl) stmtNode()     {}
+func (*ReturnStmt) stmtNode()   {}
+func (*BranchStmt) stmtNode()   {}
+func (*LabelStmt) stmtNode()    {}
+func (*GotoStmt) stmtNode()     {}
+func (*AllocStmt) stmtNode()    {}
+func (*KeywordStmt) stmtNode()  {}
+func (*Block) stmtNode()        {}
+func (*PreDirective) stmtNode() {}
+func (*BadNode) stmtNode()      {}
@@ -0,0 +1,228 @@
+/*
+   Copyright 2020 Kyle Gunger
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+
+package ast
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"tparse"
+)
+
+func parseString(t *testing.T, src string) *File {
+	t.Helper()
+	n, diags, err := tparse.Parse(strings.NewReader(src), "test.tnsl")
+	if err != nil || tparse.HasErrors(diags) {
+		t.Fatalf("parsing %q: %v %v", src, err, diags)
+	}
+	return FromNode(n)
+}
+
+// Every BadNode reachable from v
+func badNodes(v reflect.Value, out *[]*BadNode) {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return
+		}
+		if b, ok := v.Interface().(*BadNode); ok {
+			*out = append(*out, b)
+			return
+		}
+		badNodes(v.Elem(), out)
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			badNodes(v.Index(i), out)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).PkgPath == "" {
+				badNodes(v.Field(i), out)
+			}
+		}
+	}
+}
+
+// Each test program which parses should convert without any BadNodes
+func TestFromNodeTests(t *testing.T) {
+	files, _ := filepath.Glob("../../../tests/*.tnsl")
+	if len(files) == 0 {
+		t.Fatal("no test programs found")
+	}
+
+	for _, f := range files {
+		fd, err := os.Open(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, diags, err := tparse.Parse(fd, f)
+		fd.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		out := FromNode(n)
+		if tparse.HasErrors(diags) {
+			continue
+		}
+
+		if len(out.Items) != len(n.Sub) {
+			t.Errorf("%s: %d items from %d nodes", f, len(out.Items), len(n.Sub))
+		}
+
+		bad := []*BadNode{}
+		badNodes(reflect.ValueOf(out), &bad)
+		for _, b := range bad {
+			t.Errorf("%s:%d:%d: %s", f, b.Pos().Line, b.Pos().Char, b.Message)
+		}
+	}
+}
+
+func TestDefine(t *testing.T) {
+	f := parseString(t, ";const {}int a = {1, 2}, b\n")
+	d, ok := f.Items[0].(*Define)
+	if !ok {
+		t.Fatalf("got %T, want *Define", f.Items[0])
+	}
+
+	if d.Type.Name != "int" || len(d.Type.Mods) != 2 || d.Type.Mods[0].Op != "const" || d.Type.Mods[1].Op != "{}" {
+		t.Errorf("type: %+v", d.Type)
+	}
+
+	if len(d.Vars) != 2 || d.Vars[0].Op != "=" || d.Vars[1].Init != nil {
+		t.Fatalf("vars: %+v", d.Vars)
+	}
+
+	if c, ok := d.Vars[0].Init.(*CompositeLit); !ok || len(c.Elems) != 2 {
+		t.Errorf("a's value: %#v", d.Vars[0].Init)
+	}
+
+	if n, ok := d.Vars[1].Name.(*Ident); !ok || n.Name != "b" {
+		t.Errorf("second name: %#v", d.Vars[1].Name)
+	}
+}
+
+func TestExpr(t *testing.T) {
+	f := parseString(t, ";x = -p.x * 2 + f(1)[uint8] + a{0}\n")
+	s, ok := f.Items[0].(*ExprStmt)
+	if !ok {
+		t.Fatalf("got %T, want *ExprStmt", f.Items[0])
+	}
+
+	set, ok := s.X.(*BinaryExpr)
+	if !ok || set.Op != "=" {
+		t.Fatalf("got %#v, want =", s.X)
+	}
+
+	// (((-(p.x)) * 2) + f(1)[uint8]) + a{0}
+	sum, ok := set.Y.(*BinaryExpr)
+	if !ok || sum.Op != "+" {
+		t.Fatalf("got %#v, want +", set.Y)
+	}
+	if i, ok := sum.Y.(*Index); !ok || len(i.Indices) != 1 {
+		t.Errorf("got %#v, want an index", sum.Y)
+	}
+
+	left, ok := sum.X.(*BinaryExpr)
+	if !ok || left.Op != "+" {
+		t.Fatalf("got %#v, want +", sum.X)
+	}
+	if c, ok := left.Y.(*Cast); !ok || c.Types[0].Name != "uint8" {
+		t.Errorf("got %#v, want a cast", left.Y)
+	} else if call, ok := c.X.(*Call); !ok || len(call.Args) != 1 {
+		t.Errorf("got %#v, want a call", c.X)
+	}
+
+	mul, ok := left.X.(*BinaryExpr)
+	if !ok || mul.Op != "*" {
+		t.Fatalf("got %#v, want *", left.X)
+	}
+	neg, ok := mul.X.(*UnaryExpr)
+	if !ok || neg.Op != "-" {
+		t.Fatalf("got %#v, want -", mul.X)
+	}
+	if dot, ok := neg.X.(*BinaryExpr); !ok || dot.Op != "." {
+		t.Errorf("got %#v, want .", neg.X)
+	}
+}
+
+func TestIs(t *testing.T) {
+	f := parseString(t, "/; main\n\t;bool b = p is Pair\n;/\n")
+	d := f.Items[0].(*Block).Body[0].(*Define)
+	is, ok := d.Vars[0].Init.(*IsExpr)
+	if !ok || is.Type.Name != "Pair" {
+		t.Fatalf("got %#v, want is Pair", d.Vars[0].Init)
+	}
+	if x, ok := is.X.(*Ident); !ok || x.Name != "p" {
+		t.Errorf("got %#v, want p", is.X)
+	}
+}
+
+func TestDecls(t *testing.T) {
+	f := parseString(t, ";struct Square extends Rect {int side, {}uint8 name}\n;enum Color [int] { RED = 1, GREEN }\n")
+
+	s, ok := f.Items[0].(*StructDecl)
+	if !ok {
+		t.Fatalf("got %T, want *StructDecl", f.Items[0])
+	}
+	if s.Name.Name != "Square" || s.Extends == nil || s.Extends.Name != "Rect" || len(s.Fields) != 2 {
+		t.Errorf("struct: %+v", s)
+	} else if s.Fields[1].Type.Name != "uint8" || len(s.Fields[1].Names) != 1 {
+		t.Errorf("second field: %+v", s.Fields[1])
+	}
+
+	e, ok := f.Items[1].(*EnumDecl)
+	if !ok {
+		t.Fatalf("got %T, want *EnumDecl", f.Items[1])
+	}
+	if e.Name.Name != "Color" || e.Type.Name != "int" || len(e.Values) != 2 || e.Values[0].Op != "=" {
+		t.Errorf("enum: %+v", e)
+	}
+}
+
+func TestBlock(t *testing.T) {
+	f := parseString(t, "/; add (int a, b) [int]\n\t/; if (a < b)\n\t\t;return b\n\t;/\n\t;return a\n;/\n")
+	b, ok := f.Items[0].(*Block)
+	if !ok {
+		t.Fatalf("got %T, want *Block", f.Items[0])
+	}
+
+	if b.Def.Name == nil || b.Def.Name.Name != "add" || b.Def.Return == nil || b.Def.Return.Name != "int" {
+		t.Errorf("def: %+v", b.Def)
+	}
+	if len(b.Def.Params) != 1 || b.Def.Params[0].Type.Name != "int" || len(b.Def.Params[0].Names) != 2 {
+		t.Errorf("params: %+v", b.Def.Params)
+	}
+
+	if len(b.Body) != 2 {
+		t.Fatalf("got %d statements, want 2", len(b.Body))
+	}
+	in, ok := b.Body[0].(*Block)
+	if !ok || len(in.Def.Keywords) != 1 || in.Def.Keywords[0] != "if" || len(in.Def.Start) != 1 {
+		t.Errorf("if block: %#v", b.Body[0])
+	}
+	if r, ok := b.Body[1].(*ReturnStmt); !ok || r.Value == nil {
+		t.Errorf("got %#v, want a return", b.Body[1])
+	}
+
+	if b.Pos().Line != 1 || b.End().Line != 6 {
+		t.Errorf("span: %v to %v", b.Pos(), b.End())
+	}
+}
@@ -0,0 +1,447 @@
+/*
+   Copyright 2020 Kyle Gunger
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package ast
+
+import "tparse"
+
+// Converting from tparse.Node.  Anything which does not have the shape the parser
+// makes is turned into a BadNode instead of failing.
+
+// FromNode converts the root of a tree made by tparse.Parse or tparse.MakeTree
+func FromNode(n tparse.Node) *File {
+	out := &File{Span: span(n), Name: n.Data.Data}
+
+	for _, s := range n.Sub {
+		out.Items = append(out.Items, StmtFromNode(s))
+	}
+
+	return out
+}
+
+// StmtFromNode converts a statement, block, or pre-processor directive
+func StmtFromNode(n tparse.Node) Stmt {
+	switch n.Data.Type {
+	case tparse.ERRNODE:
+		return badFromNode(n)
+	case 11:
+		return preFromNode(n)
+	case tparse.KEYWORD:
+		return keywordFromNode(n)
+	case 10:
+		switch n.Data.Data {
+		case "block":
+			return blockFromNode(n)
+		case "define":
+			return defineFromNode(n)
+		case "value":
+			if len(n.Sub) == 1 {
+				return &ExprStmt{Span: span(n), X: ExprFromNode(n.Sub[0])}
+			}
+		}
+	}
+
+	return bad(n, "Unexpected node in place of a statement")
+}
+
+// ExprFromNode converts a value
+func ExprFromNode(n tparse.Node) Expr {
+	var out Expr
+	post := n.Sub
+
+	switch n.Data.Type {
+	case tparse.ERRNODE:
+		return badFromNode(n)
+	case tparse.LITERAL:
+		out = &BasicLit{Span: tokSpan(n.Data), Kind: n.Data.Kind, Value: n.Data.Data}
+	case tparse.DEFWORD:
+		out = &Ident{Span: tokSpan(n.Data), Name: n.Data.Data}
+	case tparse.AUGMENT:
+		if n.Data.Data == "is" && len(n.Sub) > 1 {
+			x, t := ExprFromNode(n.Sub[0]), TypeFromNode(n.Sub[1])
+			out = &IsExpr{Span: join(tokSpan(n.Data), x, t), X: x, Type: t}
+			post = n.Sub[2:]
+		} else if len(n.Sub) > 1 && !isPostfix(n.Sub[1]) {
+			x, y := ExprFromNode(n.Sub[0]), ExprFromNode(n.Sub[1])
+			out = &BinaryExpr{Span: join(tokSpan(n.Data), x, y), Op: n.Data.Data, X: x, Y: y}
+			post = n.Sub[2:]
+		} else if len(n.Sub) > 0 {
+			x := ExprFromNode(n.Sub[0])
+			out = &UnaryExpr{Span: join(tokSpan(n.Data), x), Op: n.Data.Data, X: x}
+			post = n.Sub[1:]
+		} else {
+			return bad(n, "Operator without a value")
+		}
+	case 10:
+		if n.Data.Data != "comp" {
+			return bad(n, "Unexpected node in place of a value")
+		}
+
+		c := &CompositeLit{}
+		post = nil
+		for i, s := range n.Sub {
+			if isPostfix(s) {
+				post = n.Sub[i:]
+				break
+			}
+			c.Elems = append(c.Elems, ExprFromNode(s))
+			c.Span = join(c.Span, c.Elems[i])
+		}
+		out = c
+	default:
+		return bad(n, "Unexpected node in place of a value")
+	}
+
+	for _, p := range post {
+		out = postfixFromNode(out, p)
+	}
+
+	return out
+}
+
+// TypeFromNode converts a type
+func TypeFromNode(n tparse.Node) *TypeExpr {
+	out := &TypeExpr{Span: span(n)}
+
+	for _, s := range n.Sub {
+		switch s.Data.Type {
+		case tparse.AUGMENT:
+			if s.Data.Data == "`" {
+				out.Post = s.Data.Data
+				continue
+			}
+
+			m := TypeMod{Span: span(s), Op: s.Data.Data}
+			if s.Data.Data == "{}" && len(s.Sub) > 0 {
+				m.Len = exprList(s.Sub[0])
+			}
+			out.Mods = append(out.Mods, m)
+		case tparse.KEYWORD:
+			out.Mods = append(out.Mods, TypeMod{Span: span(s), Op: s.Data.Data})
+		case tparse.KEYTYPE, tparse.DEFWORD:
+			// Each word but the last is a module the type is in
+			if out.Name != "" {
+				out.Path = append(out.Path, out.Name)
+			}
+			out.Name = s.Data.Data
+
+			for _, p := range s.Sub {
+				if p.Data.Data == "()" {
+					out.Args = typeList(p)
+				} else if p.Data.Data == "[]" {
+					out.Returns = typeList(p)
+				}
+			}
+		}
+	}
+
+	return out
+}
+
+// Helpers
+
+func span(n tparse.Node) Span {
+	return Span{n.Start, n.End}
+}
+
+func tokSpan(t tparse.Token) Span {
+	if t.Line == 0 {
+		return Span{}
+	}
+	return Span{t.Start(), t.End}
+}
+
+// Smallest span covering all the given spans and nodes.  Unknown spans are skipped.
+func join(s Span, nodes ...Node) Span {
+	for _, n := range nodes {
+		if n.Pos().Line == 0 {
+			continue
+		}
+
+		if s.From.Line == 0 || n.Pos().Offset < s.From.Offset {
+			s.From = n.Pos()
+		}
+
+		if s.To.Line == 0 || n.End().Offset > s.To.Offset {
+			s.To = n.End()
+		}
+	}
+
+	return s
+}
+
+func isEmpty(n tparse.Node) bool {
+	return n.Data.Type == 0 && n.Data.Data == "" && len(n.Sub) == 0
+}
+
+func bad(n tparse.Node, message string) *BadNode {
+	return &BadNode{Span: span(n), Message: message, Tokens: []tparse.Token{n.Data}}
+}
+
+func badFromNode(n tparse.Node) *BadNode {
+	out := &BadNode{Span: span(n), Message: n.Data.Data}
+	for _, s := range n.Sub {
+		out.Tokens = append(out.Tokens, s.Data)
+	}
+	return out
+}
+
+// Is the node a postfix operation (call, cast, index, or postfix operator) on the value before it
+func isPostfix(n tparse.Node) bool {
+	if n.Data.Type == 10 {
+		return n.Data.Data == "call" || n.Data.Data == "cast" || n.Data.Data == "index"
+	}
+
+	_, prs := tparse.UNARY_POST[n.Data.Data]
+	return n.Data.Type == tparse.AUGMENT && prs && len(n.Sub) == 0
+}
+
+func postfixFromNode(x Expr, n tparse.Node) Expr {
+	s := join(span(n), x)
+
+	switch n.Data.Data {
+	case "call":
+		return &Call{Span: s, Fun: x, Args: exprList(n)}
+	case "index":
+		return &Index{Span: s, X: x, Indices: exprList(n)}
+	case "cast":
+		return &Cast{Span: s, X: x, Types: typeList(n)}
+	}
+
+	if isPostfix(n) {
+		return &PostfixExpr{Span: s, Op: n.Data.Data, X: x}
+	}
+
+	return bad(n, "Unexpected node after a value")
+}
+
+func exprList(n tparse.Node) []Expr {
+	out := []Expr{}
+	for _, s := range n.Sub {
+		out = append(out, ExprFromNode(s))
+	}
+	return out
+}
+
+func typeList(n tparse.Node) []*TypeExpr {
+	out := []*TypeExpr{}
+	for _, s := range n.Sub {
+		out = append(out, TypeFromNode(s))
+	}
+	return out
+}
+
+func stmtList(n tparse.Node) []Stmt {
+	out := []Stmt{}
+	for _, s := range n.Sub {
+		out = append(out, StmtFromNode(s))
+	}
+	return out
+}
+
+// Is the node an assignment (= or a compound assignment like +=)
+func isAssign(n tparse.Node) bool {
+	return n.Data.Type == tparse.AUGMENT && tparse.ORDER[n.Data.Data] == tparse.ORDER["="] && len(n.Sub) == 2
+}
+
+// Variables (and their values) from a vlist
+func varList(n tparse.Node) []*Var {
+	out := []*Var{}
+	for _, s := range n.Sub {
+		if isAssign(s) {
+			out = append(out, &Var{Span: span(s), Name: ExprFromNode(s.Sub[0]), Op: s.Data.Data, Init: ExprFromNode(s.Sub[1])})
+		} else {
+			out = append(out, &Var{Span: span(s), Name: ExprFromNode(s)})
+		}
+	}
+	return out
+}
+
+// Parameters from a plist (each type is followed by the names which have it)
+func paramList(n tparse.Node) []*Param {
+	out := []*Param{}
+	var cur *Param
+
+	for _, s := range n.Sub {
+		if s.Data.Type == 10 && s.Data.Data == "type" {
+			cur = &Param{Span: span(s), Type: TypeFromNode(s)}
+			out = append(out, cur)
+			continue
+		}
+
+		if cur == nil {
+			cur = &Param{Span: span(s)}
+			out = append(out, cur)
+		}
+
+		name := ExprFromNode(s)
+		cur.Names = append(cur.Names, name)
+		cur.Span = join(cur.Span, name)
+	}
+
+	return out
+}
+
+// Statements
+
+func defineFromNode(n tparse.Node) Stmt {
+	if len(n.Sub) != 2 {
+		return bad(n, "Malformed definition")
+	}
+
+	return &Define{Span: span(n), Type: TypeFromNode(n.Sub[0]), Vars: varList(n.Sub[1])}
+}
+
+func keywordFromNode(n tparse.Node) Stmt {
+	s := span(n)
+	kw := n.Data.Data
+
+	// Keyword statements always have a sub-node, but it is empty if nothing followed the keyword
+	var arg *tparse.Node
+	if len(n.Sub) > 0 && !isEmpty(n.Sub[len(n.Sub) - 1]) {
+		arg = &(n.Sub[len(n.Sub) - 1])
+	}
+
+	switch kw {
+	case "struct", "raw":
+		out := &StructDecl{Span: s, Raw: kw == "raw"}
+		for _, sub := range n.Sub {
+			switch {
+			case sub.Data.Type == tparse.DEFWORD:
+				out.Name = &Ident{Span: tokSpan(sub.Data), Name: sub.Data.Data}
+			case sub.Data.Data == "vlist":
+				out.Params = exprList(sub)
+			case sub.Data.Data == "extends" && len(sub.Sub) > 0:
+				out.Extends = TypeFromNode(sub.Sub[0])
+			case sub.Data.Data == "plist":
+				out.Fields = paramList(sub)
+			}
+		}
+		return out
+	case "enum":
+		out := &EnumDecl{Span: s}
+		for _, sub := range n.Sub {
+			switch {
+			case sub.Data.Type == tparse.DEFWORD:
+				out.Name = &Ident{Span: tokSpan(sub.Data), Name: sub.Data.Data}
+			case sub.Data.Data == "type":
+				out.Type = TypeFromNode(sub)
+			case sub.Data.Data == "vlist":
+				out.Values = varList(sub)
+			}
+		}
+		return out
+	case "return":
+		out := &ReturnStmt{Span: s}
+		if arg != nil {
+			out.Value = ExprFromNode(*arg)
+		}
+		return out
+	case "break", "continue":
+		out := &BranchStmt{Span: s, Keyword: kw}
+		if arg != nil {
+			out.Level = ExprFromNode(*arg)
+		}
+		return out
+	case "label", "goto":
+		if arg == nil {
+			return bad(n, "Missing label name")
+		}
+
+		name := &Ident{Span: tokSpan(arg.Data), Name: arg.Data.Data}
+		if kw == "label" {
+			return &LabelStmt{Span: s, Name: name}
+		}
+		return &GotoStmt{Span: s, Label: name}
+	case "alloc", "salloc", "realloc", "delete":
+		out := &AllocStmt{Span: s, Keyword: kw}
+		if arg != nil {
+			out.Args = exprList(*arg)
+		}
+		return out
+	}
+
+	return &KeywordStmt{Span: s, Keyword: kw}
+}
+
+// Blocks
+
+func blockFromNode(n tparse.Node) Stmt {
+	out := &Block{Span: span(n), Def: &BlockDef{}}
+	body := n.Sub
+
+	if len(body) > 0 && body[0].Data.Type == 10 && body[0].Data.Data == "bdef" {
+		out.Def = defFromNode(body[0])
+		body = body[1:]
+	}
+
+	for _, s := range body {
+		out.Body = append(out.Body, StmtFromNode(s))
+	}
+
+	return out
+}
+
+func defFromNode(n tparse.Node) *BlockDef {
+	out := &BlockDef{Span: span(n)}
+	sparse := false
+
+	for _, s := range n.Sub {
+		switch s.Data.Type {
+		case tparse.DEFWORD:
+			out.Name = &Ident{Span: tokSpan(s.Data), Name: s.Data.Data}
+		case tparse.AUGMENT:
+			out.Keywords = append(out.Keywords, "operator")
+			out.Operator = s.Data.Data
+		case tparse.KEYWORD:
+			switch s.Data.Data {
+			case "delete":
+				out.Keywords = append(out.Keywords, "operator")
+				out.Operator = s.Data.Data
+				continue
+			case "if", "else", "match", "case", "loop":
+				sparse = true
+			case "module", "method", "interface":
+				if len(s.Sub) > 0 {
+					out.Name = &Ident{Span: tokSpan(s.Sub[0].Data), Name: s.Sub[0].Data.Data}
+				}
+			}
+			out.Keywords = append(out.Keywords, s.Data.Data)
+		case 10:
+			if s.Data.Data == "()" && sparse {
+				out.Start = stmtList(s)
+			} else if s.Data.Data == "()" {
+				out.Params = paramList(s)
+			} else if s.Data.Data == "[]" && sparse {
+				out.Each = stmtList(s)
+			} else if s.Data.Data == "[]" {
+				out.Return = TypeFromNode(s)
+			}
+		}
+	}
+
+	return out
+}
+
+// Pre-processor
+
+func preFromNode(n tparse.Node) Stmt {
+	out := &PreDirective{Span: span(n), Name: n.Data.Data}
+	for _, s := range n.Sub {
+		out.Args = append(out.Args, s.Data)
+	}
+	return out
+}
@@ -0,0 +1,213 @@
+/*
+   Copyright 2020 Kyle Gunger
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package tparse
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+/**
+	json.go - read and write tokens and trees as JSON.
+
+	The schema is described in the README under "Output formats".  Token, literal,
+	and trivia types are written as the names of their constants (DEFWORD, INTLIT,
+	LINECOMMENT) so the output does not depend on the numbers behind them.
+*/
+
+// JSONVERSION is the version of the JSON schema.  It changes whenever the schema does.
+const JSONVERSION = 2
+
+// TYPENAMES gives the name used in JSON for each token type
+var TYPENAMES = map[int]string{
+	-1:      "EOF",
+	LINESEP: "LINESEP",
+	INLNSEP: "INLNSEP",
+	DELIMIT: "DELIMIT",
+	AUGMENT: "AUGMENT",
+	LITERAL: "LITERAL",
+	KEYTYPE: "KEYTYPE",
+	PREWORD: "PREWORD",
+	KEYWORD: "KEYWORD",
+	DEFWORD: "DEFWORD",
+	9:       "ROOT",
+	10:      "ASTNODE",
+	11:      "PREPROC",
+	ERRNODE: "ERRNODE",
+}
+
+// KINDNAMES gives the name used in JSON for each kind of literal
+var KINDNAMES = map[int]string{
+	INTLIT:   "INTLIT",
+	FLOATLIT: "FLOATLIT",
+	CHARLIT:  "CHARLIT",
+	STRLIT:   "STRLIT",
+	BOOLLIT:  "BOOLLIT",
+}
+
+// TRIVIANAMES gives the name used in JSON for each type of trivia
+var TRIVIANAMES = map[int]string{
+	WHITESPACE:   "WHITESPACE",
+	LINECOMMENT:  "LINECOMMENT",
+	BLOCKCOMMENT: "BLOCKCOMMENT",
+}
+
+// JSONFile is the top level object of a JSON file.  Kind is "tokens", "tree", or
+// "module" and says which of Tokens, Tree, or Module is filled in.
+// Modules are built by texec, so they are left undecoded here.
+type JSONFile struct {
+	Version int             `json:"version"`
+	Kind    string          `json:"kind"`
+	File    string          `json:"file"`
+	Tokens  []Token         `json:"tokens,omitempty"`
+	Tree    *Node           `json:"tree,omitempty"`
+	Module  json.RawMessage `json:"module,omitempty"`
+}
+
+type jsonToken struct {
+	Type     string   `json:"type"`
+	Data     string   `json:"data"`
+	Kind     string   `json:"kind,omitempty"`
+	Raw      string   `json:"raw,omitempty"`
+	Start    Pos      `json:"start"`
+	End      Pos      `json:"end"`
+	Leading  []Trivia `json:"leading,omitempty"`
+	Trailing []Trivia `json:"trailing,omitempty"`
+}
+
+type jsonTrivia struct {
+	Type string `json:"type"`
+	Data string `json:"data"`
+}
+
+// Find the number a name stands for
+func lookupName(names map[int]string, name, what string) (int, error) {
+	for k, v := range names {
+		if v == name {
+			return k, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown %s %q", what, name)
+}
+
+// MarshalJSON writes a token using the names of its type and kind
+func (t Token) MarshalJSON() ([]byte, error) {
+	name, prs := TYPENAMES[t.Type]
+	if !prs {
+		return nil, fmt.Errorf("token %q has unknown type %d", t.Data, t.Type)
+	}
+
+	return json.Marshal(jsonToken{
+		Type: name,
+		Data: t.Data,
+		Kind: KINDNAMES[t.Kind],
+		Raw: t.Raw,
+		Start: t.Start(),
+		End: t.End,
+		Leading: t.Leading,
+		Trailing: t.Trailing,
+	})
+}
+
+// UnmarshalJSON reads a token written by MarshalJSON
+func (t *Token) UnmarshalJSON(b []byte) error {
+	jt := jsonToken{}
+	if err := json.Unmarshal(b, &jt); err != nil {
+		return err
+	}
+
+	typ, err := lookupName(TYPENAMES, jt.Type, "token type")
+	if err != nil {
+		return err
+	}
+
+	kind := 0
+	if jt.Kind != "" {
+		if kind, err = lookupName(KINDNAMES, jt.Kind, "literal kind"); err != nil {
+			return err
+		}
+	}
+
+	*t = Token{
+		Type: typ,
+		Data: jt.Data,
+		Line: jt.Start.Line,
+		Char: jt.Start.Char,
+		Kind: kind,
+		Offset: jt.Start.Offset,
+		End: jt.End,
+		Raw: jt.Raw,
+		Leading: jt.Leading,
+		Trailing: jt.Trailing,
+	}
+	return nil
+}
+
+// MarshalJSON writes trivia using the name of its type
+func (tv Trivia) MarshalJSON() ([]byte, error) {
+	name, prs := TRIVIANAMES[tv.Type]
+	if !prs {
+		return nil, fmt.Errorf("trivia has unknown type %d", tv.Type)
+	}
+	return json.Marshal(jsonTrivia{name, tv.Data})
+}
+
+// UnmarshalJSON reads trivia written by MarshalJSON
+func (tv *Trivia) UnmarshalJSON(b []byte) error {
+	jt := jsonTrivia{}
+	if err := json.Unmarshal(b, &jt); err != nil {
+		return err
+	}
+
+	typ, err := lookupName(TRIVIANAMES, jt.Type, "trivia type")
+	if err != nil {
+		return err
+	}
+
+	*tv = Trivia{typ, jt.Data}
+	return nil
+}
+
+// WriteJSON writes f to w, filling in the schema version
+func WriteJSON(w io.Writer, f JSONFile) error {
+	f.Version = JSONVERSION
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(f)
+}
+
+// ReadJSON loads a file written by WriteJSON
+func ReadJSON(r io.Reader) (JSONFile, error) {
+	f := JSONFile{}
+	if err := json.NewDecoder(r).Decode(&f); err != nil {
+		return f, err
+	}
+
+	if f.Version != JSONVERSION {
+		return f, fmt.Errorf("unsupported JSON version %d (expected %d)", f.Version, JSONVERSION)
+	}
+
+	switch f.Kind {
+	case "tokens", "tree", "module":
+	default:
+		return f, fmt.Errorf("unknown JSON kind %q", f.Kind)
+	}
+
+	return f, nil
+}
@@ -0,0 +1,237 @@
+/*
+   Copyright 2020 Kyle Gunger
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package tparse
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/*
+	Numeric literals:
+
+		decimal  1  1_000  017 (still decimal)
+		based    0x1F  0b1010  0o17
+		float    1.5  1.5e10  2E-3  1e6
+		suffix   5i32  7u8  0xFFu16  3f32
+
+	Underscores may only sit between two digits.  A float needs digits on both
+	sides of its point.  Based literals are always integers.  An integer must fit
+	in the type of its suffix, or in an int if it has none (an unsuffixed based
+	literal may use all 64 bits, so masks like 0xFFFF_FFFF_FFFF_FFFF can be
+	written).  A signed literal may be one past its type's largest value, but only
+	right after a unary -, as in -128i8.
+*/
+
+// LITSUFFIX maps the typed suffixes a numeric literal may end with to the type they give it
+var LITSUFFIX = map[string]string{
+	"i":   "int",
+	"i8":  "int8",
+	"i16": "int16",
+	"i32": "int32",
+	"i64": "int64",
+
+	"u":   "uint",
+	"u8":  "uint8",
+	"u16": "uint16",
+	"u32": "uint32",
+	"u64": "uint64",
+
+	"f":   "float",
+	"f32": "float32",
+	"f64": "float64",
+}
+
+// SplitNumber splits a numeric literal into its number and its typed suffix (if any)
+func SplitNumber(s string) (string, string) {
+	start := 0
+	hex := false
+	if pre := numberBase(s); pre != 10 {
+		start = 2
+		hex = pre == 16
+	}
+
+	for i := start; i < len(s); i++ {
+		if s[i] == 'i' || s[i] == 'u' || (s[i] == 'f' && !hex) {
+			return s[:i], s[i:]
+		}
+	}
+
+	return s, ""
+}
+
+// NumberDigits gives the digits of a numeric literal (no prefix, suffix, or separators) and their base
+func NumberDigits(s string) (string, int) {
+	num, _ := SplitNumber(s)
+	base := numberBase(num)
+	if base != 10 {
+		num = num[2:]
+	}
+
+	return strings.ReplaceAll(num, "_", ""), base
+}
+
+// Base of a numeric literal from its prefix
+func numberBase(s string) int {
+	if len(s) < 2 || s[0] != '0' {
+		return 10
+	}
+
+	switch s[1] {
+	case 'x', 'X':
+		return 16
+	case 'b', 'B':
+		return 2
+	case 'o', 'O':
+		return 8
+	}
+
+	return 10
+}
+
+func isDigit(c byte, base int) bool {
+	switch {
+	case c >= '0' && c <= '9':
+		return int(c - '0') < base
+	case c >= 'a' && c <= 'f':
+		return base == 16
+	case c >= 'A' && c <= 'F':
+		return base == 16
+	}
+	return false
+}
+
+// Check a run of digits (with separators), returning a problem if there is one
+func checkDigits(s string, base int, what string) string {
+	if len(s) == 0 {
+		return fmt.Sprintf("missing digits in %s", what)
+	}
+
+	for i := 0; i < len(s); i++ {
+		if s[i] == '_' {
+			if i == 0 || i == len(s) - 1 || s[i - 1] == '_' {
+				return "'_' must separate digits"
+			}
+		} else if !isDigit(s[i], base) {
+			return fmt.Sprintf("invalid digit %q in %s", s[i], what)
+		}
+	}
+
+	return ""
+}
+
+// Check a numeric literal against the grammar.  Returns the literal's kind and
+// a description of what is wrong with it (empty if nothing is).
+func checkNumber(s string) (int, string) {
+	num, suf := SplitNumber(s)
+	kind := INTLIT
+
+	typ, ok := LITSUFFIX[suf]
+	if suf != "" && !ok {
+		return kind, fmt.Sprintf("unknown suffix %q", suf)
+	} else if strings.HasPrefix(typ, "float") {
+		kind = FLOATLIT
+	}
+
+	base := numberBase(num)
+	if base != 10 {
+		name := map[int]string{16: "hexadecimal literal", 2: "binary literal", 8: "octal literal"}[base]
+		if kind == FLOATLIT {
+			return kind, fmt.Sprintf("%s can not have a float suffix", name)
+		}
+		if msg := checkDigits(num[2:], base, name); msg != "" {
+			return kind, msg
+		}
+		return kind, checkRange(num[2:], base, typ)
+	}
+
+	mant, exp := num, ""
+	e := strings.IndexAny(num, "eE")
+	if e >= 0 {
+		mant, exp = num[:e], num[e + 1:]
+		kind = FLOATLIT
+	}
+
+	if p := strings.IndexByte(mant, '.'); p >= 0 {
+		kind = FLOATLIT
+		if msg := checkDigits(mant[:p], 10, "integer part"); msg != "" {
+			return kind, msg
+		}
+		mant = mant[p + 1:]
+		if msg := checkDigits(mant, 10, "fraction"); msg != "" {
+			return kind, msg
+		}
+	} else if msg := checkDigits(mant, 10, "decimal literal"); msg != "" {
+		return kind, msg
+	}
+
+	if e >= 0 {
+		if len(exp) > 0 && (exp[0] == '+' || exp[0] == '-') {
+			exp = exp[1:]
+		}
+		return kind, checkDigits(exp, 10, "exponent")
+	}
+
+	if kind == INTLIT {
+		return kind, checkRange(mant, 10, typ)
+	}
+	return kind, ""
+}
+
+// Bits and sign of the type an integer literal must fit in
+func rangeOf(base int, typ string) (string, int, bool) {
+	if typ == "" {
+		if base != 10 {
+			return "uint64", 64, false
+		}
+		typ = "int"
+	}
+
+	bits := 64
+	if n := strings.TrimPrefix(strings.TrimPrefix(typ, "u"), "int"); n != "" {
+		bits, _ = strconv.Atoi(n)
+	}
+	return typ, bits, typ[0] == 'i'
+}
+
+// Check that the (already valid) digits of an integer fit in its type
+func checkRange(s string, base int, typ string) string {
+	typ, bits, signed := rangeOf(base, typ)
+
+	v, err := strconv.ParseUint(strings.ReplaceAll(s, "_", ""), base, bits)
+	if signed && err == nil && v > 1 << (bits - 1) {
+		err = strconv.ErrRange
+	}
+	if err != nil {
+		return fmt.Sprintf("out of range for %s", typ)
+	}
+	return ""
+}
+
+// Gives the signed type an integer literal is one past the largest value of
+// (so it may only be negated), or "" if there is none
+func limitType(s string) string {
+	_, suf := SplitNumber(s)
+	digits, base := NumberDigits(s)
+	typ, bits, signed := rangeOf(base, LITSUFFIX[suf])
+
+	if v, err := strconv.ParseUint(digits, base, 64); signed && err == nil && v == 1 << (bits - 1) {
+		return typ
+	}
+	return ""
+}
@@ -0,0 +1,117 @@
+/*
+   Copyright 2020 Kyle Gunger
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package tparse
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Name of a token's type, or its number if it has no name
+func typeName(t Token) string {
+	if name, prs := TYPENAMES[t.Type]; prs {
+		return name
+	}
+	return fmt.Sprint(t.Type)
+}
+
+// SExprToken writes a token as (TYPE "data" line char)
+func SExprToken(t Token) string {
+	return fmt.Sprintf("(%s %s %d %d)", typeName(t), strconv.Quote(t.Data), t.Line, t.Char)
+}
+
+// SExprTokens writes a list of tokens as an s-expression, one token per line
+func SExprTokens(toks []Token) string {
+	b := strings.Builder{}
+	b.WriteString("(tokens")
+	for _, t := range toks {
+		b.WriteString("\n  " + SExprToken(t))
+	}
+	b.WriteString(")\n")
+	return b.String()
+}
+
+// SExprNode writes a tree as nested (TYPE "data" line char sub-nodes...) lists
+func SExprNode(n Node) string {
+	b := strings.Builder{}
+	sexprNode(&b, n, 0)
+	b.WriteString("\n")
+	return b.String()
+}
+
+func sexprNode(b *strings.Builder, n Node, depth int) {
+	tok := SExprToken(n.Data)
+	b.WriteString(tok[:len(tok) - 1])
+
+	for _, s := range n.Sub {
+		b.WriteString("\n" + strings.Repeat("  ", depth + 1))
+		sexprNode(b, s, depth + 1)
+	}
+
+	b.WriteString(")")
+}
+
+// DotQuote quotes a string for use as an ID or label in a Graphviz file
+func DotQuote(s string) string {
+	return "\"" + strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n").Replace(s) + "\""
+}
+
+// Label for a token in a Graphviz file
+func dotLabel(t Token) string {
+	return DotQuote(typeName(t) + "\n" + t.Data)
+}
+
+// DotTokens writes a list of tokens as a Graphviz graph, with each token pointing to the next
+func DotTokens(toks []Token, name string) string {
+	b := strings.Builder{}
+	fmt.Fprintf(&b, "digraph %s {\n\trankdir=LR;\n", DotQuote(name))
+
+	for i, t := range toks {
+		fmt.Fprintf(&b, "\tt%d [label=%s];\n", i, dotLabel(t))
+		if i > 0 {
+			fmt.Fprintf(&b, "\tt%d -> t%d;\n", i - 1, i)
+		}
+	}
+
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// DotNode writes a tree as a Graphviz graph
+func DotNode(n Node, name string) string {
+	b := strings.Builder{}
+	fmt.Fprintf(&b, "digraph %s {\n", DotQuote(name))
+	id := 0
+	DotSubgraph(&b, n, "n", &id)
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// DotSubgraph writes the nodes and edges of a tree to b, naming each node prefix
+// followed by a number (counted by id).  Returns the name of the root node.
+func DotSubgraph(b *strings.Builder, n Node, prefix string, id *int) string {
+	me := fmt.Sprintf("%s%d", prefix, *id)
+	*id++
+
+	fmt.Fprintf(b, "\t%s [label=%s];\n", me, dotLabel(n.Data))
+	for _, s := range n.Sub {
+		fmt.Fprintf(b, "\t%s -> %s;\n", me, DotSubgraph(b, s, prefix, id))
+	}
+
+	return me
+}
@@ -16,19 +16,97 @@
 
 package tparse
 
+import "strings"
+
 // Token represents a token in a program
 type Token struct {
 	Type int
 	Data string
 	Line int
 	Char int
+
+	// What kind of value a LITERAL token holds (INTLIT, FLOATLIT, ...), zero for other tokens
+	Kind int
+
+	// Byte offset of the token in the file
+	Offset int
+
+	// Position just after the last rune of the token
+	End Pos
+
+	// The token's text in the source, if it is different from Data.
+	// Comment switching delimiters like ";#" are stored as the block delimiter they stand for.
+	Raw string
+
+	// Whitespace and comments around the token, only kept if the scanner was asked to (see Scanner.KeepTrivia)
+	Leading  []Trivia
+	Trailing []Trivia
+}
+
+// Pos represents a position in a file
+type Pos struct {
+	// Bytes from the start of the file
+	Offset int `json:"offset"`
+	// Line, starting at 1 (0 if the position is unknown)
+	Line int `json:"line"`
+	// Column counted in runes, starting at 0
+	Char int `json:"char"`
+}
+
+// Start gives the position of the token's first rune
+func (t Token) Start() Pos {
+	return Pos{Offset: t.Offset, Line: t.Line, Char: t.Char}
+}
+
+// Text gives the token as it was written in the source (without trivia)
+func (t Token) Text() string {
+	if t.Raw != "" {
+		return t.Raw
+	}
+	return t.Data
+}
+
+// WHITESPACE represents a run of whitespace
+const WHITESPACE = 0
+
+// LINECOMMENT represents a line comment, not including the line break after it
+const LINECOMMENT = 1
+
+// BLOCKCOMMENT represents a block comment, or the part of one between comment switching delimiters
+const BLOCKCOMMENT = 2
+
+// Trivia represents whitespace or a comment kept between tokens
+type Trivia struct {
+	Type int
+	Data string
+}
+
+// Source rebuilds the token's original text, including its trivia
+func (t Token) Source() string {
+	b := strings.Builder{}
+
+	for _, tv := range t.Leading {
+		b.WriteString(tv.Data)
+	}
+
+	b.WriteString(t.Text())
+
+	for _, tv := range t.Trailing {
+		b.WriteString(tv.Data)
+	}
+
+	return b.String()
 }
 
 // Node represents a node in an AST
 type Node struct {
-	Data Token
+	Data Token `json:"token"`
+
+	Sub  []Node `json:"sub,omitempty"`
 
-	Sub  []Node
+	// Span of source the node and its sub-nodes cover (see SetSpans)
+	Start Pos `json:"start"`
+	End   Pos `json:"end"`
 }
 
 func makeParent(parent *Node, child Node) {
@@ -18,99 +18,475 @@ package tparse
 
 import (
 	"bufio"
+	"fmt"
 	"io"
 	"os"
 	"strings"
 	"unicode"
 )
 
-// Read in a number (may be a float)
-func numericLiteral(r *bufio.Reader, line int, char *int) Token {
-	decimal, base := false, false
-	run, _, err := r.ReadRune()
-	last := *char
+// Scanner reads tokens one at a time from a source file
+type Scanner struct {
+	read *bufio.Reader
+	file string
+
+	// Position of the next rune
+	line, char, offset int
+
+	// Reading pre-processor words, inside a block comment
+	pre, bc bool
+	// The token which opened the block comment
+	copen Token
+
+	// Keeping whitespace and comments, and those waiting for a token.
+	// Pending trivia from cstart on belong to the open block comment.
+	trivia  bool
+	pending []Trivia
+	comment strings.Builder
+	cstart  int
+
+	max   int
+	queue []queued
+	err   error
+}
+
+// A token waiting to be returned by Next, and any problem found when reading it
+type queued struct {
+	tok Token
+	err error
+}
+
+// NewScanner creates a scanner which reads tokens from r.
+// The file name is used when reporting problems.
+func NewScanner(r io.Reader, filename string) *Scanner {
+	return &Scanner{read: bufio.NewReader(r), file: filename, line: 1, max: maxResRunes()}
+}
+
+// KeepTrivia makes the scanner attach whitespace and comments to the tokens around them
+// instead of throwing them away, so that the source can be rebuilt from the tokens
+// (see Token.Source).  It must be called before the first call to Next.
+func (s *Scanner) KeepTrivia() {
+	s.trivia = true
+}
+
+// Next returns the next token, or io.EOF once the source has run out.
+// If the token is malformed it is still returned, along with a Diagnostic describing the problem,
+// and scanning may continue.  Any other error means the source could not be read, and is
+// returned from every call after it.
+func (s *Scanner) Next() (Token, error) {
+	// When keeping trivia, hold on to the last token until we know what trails it
+	for len(s.queue) == 0 || (s.trivia && len(s.queue) < 2 && s.err == nil) {
+		if s.err != nil {
+			return Token{}, s.err
+		}
+
+		err := s.scan()
+		if err != nil && err != io.EOF {
+			err = fmt.Errorf("%s: %w", s.file, err)
+		}
+
+		if err == io.EOF && s.bc {
+			// The block comment was never closed, report it on an empty token where it opened
+			at := Token{Type: -1, Line: s.copen.Line, Char: s.copen.Char, Offset: s.copen.Offset}
+			at.End = at.Start()
+			s.queue = append(s.queue, queued{at, s.diag("Unterminated block comment", s.copen)})
+		}
+
+		if err != nil && s.trivia {
+			s.flushTrivia()
+		}
+		s.err = err
+	}
+
+	q := s.queue[0]
+	s.queue = s.queue[1:]
+	return q.tok, q.err
+}
+
+// Read the next rune, keeping track of where we are in the file.
+// The rune's source text is written to b if it is not nil.
+func (s *Scanner) next(b *strings.Builder) (rune, error) {
+	r, size, err := s.read.ReadRune()
+	if err != nil {
+		return r, err
+	}
+
+	if b != nil {
+		if r == unicode.ReplacementChar && size == 1 {
+			// Keep invalid bytes as they are
+			s.read.UnreadRune()
+			c, _ := s.read.ReadByte()
+			b.WriteByte(c)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+
+	s.offset += size
+	if r == '\n' {
+		s.line++
+		s.char = 0
+	} else {
+		s.char++
+	}
+
+	return r, nil
+}
+
+// Look at the next rune without reading it
+func (s *Scanner) peek() (rune, error) {
+	r, _, err := s.read.ReadRune()
+	if err == nil {
+		s.read.UnreadRune()
+	}
+	return r, err
+}
+
+func (s *Scanner) diag(message string, t Token) error {
+	d := newDiag(message, t)
+	d.File = s.file
+	return d
+}
+
+// Start a token at the current position
+func (s *Scanner) start(typ int) Token {
+	return Token{Type: typ, Line: s.line, Char: s.char, Offset: s.offset}
+}
+
+// Add a piece of trivia, merging it with the last one if they are both whitespace
+func (s *Scanner) addTrivia(t Trivia) {
+	l := len(s.pending)
+	if t.Type == WHITESPACE && l > s.cstart && s.pending[l - 1].Type == WHITESPACE {
+		s.pending[l - 1].Data += t.Data
+		return
+	}
+	s.pending = append(s.pending, t)
+}
+
+// Queue a token (if it is not in a block comment) along with any problem it had
+func (s *Scanner) emit(t Token, err error) {
+	t.End = endPos(t)
+	out := s.filter(t)
+	for i := range out {
+		q := queued{tok: out[i]}
+		if i == len(out) - 1 {
+			q.err = err
+		}
+
+		if s.trivia {
+			s.attachTrivia(&q.tok)
+		}
+
+		s.queue = append(s.queue, q)
+	}
+}
+
+// Find where a token ends from its text
+func endPos(t Token) Pos {
+	out := t.Start()
+	text := t.Text()
+	out.Offset += len(text)
+
+	for _, r := range text {
+		if r == '\n' {
+			out.Line++
+			out.Char = 0
+		} else {
+			out.Char++
+		}
+	}
+
+	return out
+}
+
+// Give the pending trivia to a token.  Anything up to the first line break
+// trails the token before it (if it is still queued), the rest leads the new token.
+func (s *Scanner) attachTrivia(t *Token) {
+	lead := s.pending
+	s.pending = nil
+	s.cstart = 0
+
+	if len(s.queue) == 0 {
+		t.Leading = lead
+		return
+	}
+
+	prev := &(s.queue[len(s.queue) - 1].tok)
+	for i := 0; i < len(lead); i++ {
+		if lead[i].Type != WHITESPACE {
+			continue
+		}
+
+		nl := strings.IndexByte(lead[i].Data, '\n')
+		if nl < 0 {
+			continue
+		}
+
+		prev.Trailing = append(prev.Trailing, lead[:i]...)
+		if nl > 0 {
+			prev.Trailing = append(prev.Trailing, Trivia{WHITESPACE, lead[i].Data[:nl]})
+		}
+
+		t.Leading = append([]Trivia{Trivia{WHITESPACE, lead[i].Data[nl:]}}, lead[i + 1:]...)
+		return
+	}
+
+	prev.Trailing = append(prev.Trailing, lead...)
+}
+
+// At the end of the source, give the remaining trivia to the last token
+func (s *Scanner) flushTrivia() {
+	if s.bc {
+		s.closeComment()
+	}
+
+	if len(s.pending) == 0 {
+		return
+	}
+
+	if len(s.queue) == 0 {
+		// Nothing but whitespace and comments, keep them in an empty token
+		s.queue = append(s.queue, queued{tok: Token{Type: -1, Leading: s.pending}})
+	} else {
+		last := &(s.queue[len(s.queue) - 1].tok)
+		last.Trailing = append(last.Trailing, s.pending...)
+	}
+
+	s.pending = nil
+}
+
+// Read the next token or set of tokens into the queue
+func (s *Scanner) scan() error {
+	var space *strings.Builder
+	if s.trivia {
+		space = &strings.Builder{}
+	}
+
+	r, err := s.peek()
+	for ; err == nil && unicode.IsSpace(r); r, err = s.peek() {
+		s.next(space)
+	}
+
+	if space != nil && space.Len() > 0 {
+		s.addTrivia(Trivia{WHITESPACE, space.String()})
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if r >= '0' && r <= '9' {
+		return s.numericLiteral()
+	} else if r == '\'' {
+		return s.charLiteral()
+	} else if r == '"' {
+		return s.stringLiteral()
+	} else if checkResRune(r) != -1 {
+		return s.runeGroups()
+	}
+
+	return s.word()
+}
+
+// Read in a number (see literal.go for the grammar)
+func (s *Scanner) numericLiteral() error {
+	point, exp, sign := false, false, false
+	out := s.start(LITERAL)
 	b := strings.Builder{}
 
-	for ; err == nil; run, _, err = r.ReadRune() {
-		if (run == '.') && !decimal && !base {
-			decimal = true
-		} else if (run == '.') && (decimal || base) {
-			break
-		} else if !unicode.IsNumber(run) {
-			if decimal || checkResRune(run) != -1 || unicode.IsSpace(run) {
+	r, err := s.peek()
+	based := false
+	for ; err == nil; r, err = s.peek() {
+		if r == '.' {
+			// Only part of the number if a digit follows, "1.a" is a member access
+			nx, _ := s.read.Peek(2)
+			if point || exp || based || len(nx) < 2 || nx[1] < '0' || nx[1] > '9' {
+				break
+			}
+			point = true
+		} else if r == '+' || r == '-' {
+			last := b.String()[b.Len() - 1]
+			if based || sign || (last != 'e' && last != 'E') {
 				break
-			} else if !base {
-				base = true
 			}
+			sign = true
+		} else if r == '_' || (r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r))) {
+			if b.Len() == 1 && numberBase(b.String() + string(r)) != 10 {
+				based = true
+			} else if (r == 'e' || r == 'E') && !based {
+				exp = true
+			}
+		} else {
+			break
 		}
-		*char++
-		b.WriteRune(run)
+		s.next(&b)
+	}
+
+	out.Data = b.String()
+
+	var problem error
+	kind, msg := checkNumber(out.Data)
+	out.Kind = kind
+	if msg != "" {
+		problem = s.diag(fmt.Sprintf("Invalid numeric literal %s: %s", out.Data, msg), out)
 	}
 
-	r.UnreadRune()
+	s.emit(out, problem)
 
-	return Token{Type: LITERAL, Data: b.String(), Line: line, Char: last}
+	if err != io.EOF {
+		return err
+	}
+	return nil
 }
 
 // Parse a string (will escape \" only in this stage)
-func stringLiteral(r *bufio.Reader, line, char *int) Token {
+func (s *Scanner) stringLiteral() error {
 	escape := false
-	run, _, err := r.ReadRune()
-	last := *char
+	out := s.start(LITERAL)
+	out.Kind = STRLIT
+	b := strings.Builder{}
 
-	if run != '"' {
-		return Token{Type: LITERAL}
-	}
+	r, err := s.next(&b)
 
-	b := strings.Builder{}
-	b.WriteRune(run)
-	run, _, err = r.ReadRune()
+	for r, err = s.peek(); err == nil; r, err = s.peek() {
+		if r == '\n' && !escape {
+			break
+		}
+
+		s.next(&b)
 
-	for ; err == nil; run, _, err = r.ReadRune() {
-		*char++
-		b.WriteRune(run)
-		if run == '\\' && !escape {
+		if r == '\\' && !escape {
 			escape = true
-		} else if (run == '"' || run == '\n') && !escape {
-			break
-		} else if escape {
-			if run == '\n' {
-				*line++
-			}
+		} else if r == '"' && !escape {
+			out.Data = b.String()
+			s.emit(out, nil)
+			return nil
+		} else {
 			escape = false
 		}
 	}
 
-	return Token{Type: LITERAL, Data: b.String(), Line: *line, Char: last}
+	out.Data = b.String()
+	s.emit(out, s.diag("Unterminated string literal", out))
+
+	if err != io.EOF {
+		return err
+	}
+	return nil
 }
 
 // Parse a character in (escape \\ or \')
-func charLiteral(r *bufio.Reader, line int, char *int) Token {
+func (s *Scanner) charLiteral() error {
 	escape := false
-	run, _, err := r.ReadRune()
-	last := *char
+	out := s.start(LITERAL)
+	out.Kind = CHARLIT
+	b := strings.Builder{}
+
+	r, err := s.next(&b)
+
+	for r, err = s.peek(); err == nil; r, err = s.peek() {
+		if r == '\n' {
+			break
+		}
 
-	if run != '\'' {
-		return Token{Type: LITERAL}
+		s.next(&b)
+
+		if r == '\\' && !escape {
+			escape = true
+		} else if r == '\'' && !escape {
+			out.Data = b.String()
+			s.emit(out, nil)
+			return nil
+		} else {
+			escape = false
+		}
 	}
 
+	out.Data = b.String()
+	s.emit(out, s.diag("Unterminated character literal", out))
+
+	if err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+// Read a run of reserved runes, split it into rune groups, and skip any line comment
+func (s *Scanner) runeGroups() error {
+	first := s.start(DELIMIT)
 	b := strings.Builder{}
-	b.WriteRune(run)
-	run, _, err = r.ReadRune()
 
-	for ; err == nil; run, _, err = r.ReadRune() {
-		b.WriteRune(run)
-		*char++
-		if run == '\\' && !escape {
-			escape = true
-		} else if (run == '\'' && !escape) || run == '\n' {
+	r, err := s.peek()
+	for ; err == nil && checkResRune(r) != -1; r, err = s.peek() {
+		s.next(&b)
+	}
+
+	rgs := splitResRunes(b.String(), s.max, first.Line, first.Char)
+
+	// Reserved runes are all one byte long
+	for i := range rgs {
+		rgs[i].Offset = first.Offset + rgs[i].Char - first.Char
+	}
+
+	// Line Comments
+	var cmt *strings.Builder
+	for i, rg := range rgs {
+		if rg.Data == "#" {
+			cmt = &strings.Builder{}
+			for _, c := range rgs[i:] {
+				cmt.WriteString(c.Data)
+			}
+
+			rgs = rgs[:i]
+			for ; err == nil && r != '\n'; r, err = s.peek() {
+				s.next(cmt)
+			}
 			break
-		} else if escape {
-			escape = false
 		}
 	}
 
-	return Token{Type: LITERAL, Data: b.String(), Line: line, Char: last}
+	for _, rg := range rgs {
+		s.emit(rg, nil)
+	}
+
+	if cmt != nil && s.trivia {
+		s.addTrivia(Trivia{LINECOMMENT, cmt.String()})
+	}
+
+	if endsDef(&rgs) {
+		s.pre = endsPre(&rgs)
+	}
+
+	if err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+// Read a word (keyword, type, or user defined)
+func (s *Scanner) word() error {
+	out := s.start(DEFWORD)
+	b := strings.Builder{}
+
+	r, err := s.peek()
+	for ; err == nil; r, err = s.peek() {
+		if unicode.IsSpace(r) || checkResRune(r) != -1 || r == '\'' || r == '"' {
+			break
+		}
+		s.next(&b)
+	}
+
+	out.Type = checkToken(b.String(), s.pre)
+	out.Data = b.String()
+	if out.Type == LITERAL && (out.Data == "true" || out.Data == "false") {
+		out.Kind = BOOLLIT
+	}
+	s.emit(out, nil)
+
+	if err != io.EOF {
+		return err
+	}
+	return nil
 }
 
 // Split reserved runes into rune groups
@@ -142,45 +518,109 @@ func splitResRunes(str string, max, line, start int) []Token {
 	return out
 }
 
-// Remove block comments
-func stripBlockComments(t []Token) []Token {
-	out := []Token{}
-	bc := false
-	for _, tok := range t {
-
-		if tok.Type == DELIMIT {
-			ch := ":"
-			switch tok.Data {
-			case ";#":
-				ch = ";"
-				fallthrough
-			case ":#":
-				out = append(out, Token{DELIMIT, ch + "/", tok.Line, tok.Char})
-				fallthrough
-			case "/#":
-				bc = true
-				continue
-			case "#;":
-				ch = ";"
-				fallthrough
-			case "#:":
-				out = append(out, Token{DELIMIT, "/" + ch, tok.Line, tok.Char})
-				fallthrough
-			case "#/":
-				bc = false
-				continue
-			default:
-				if bc {
-					continue
-				}
+// Remove tokens inside block comments, and replace comment switching delimiters with
+// the block delimiters they stand for.  When keeping trivia, the removed tokens become
+// block comment trivia.
+func (s *Scanner) filter(tok Token) []Token {
+	if tok.Type == DELIMIT {
+		ch := ":"
+		switch tok.Data {
+		case ";#":
+			ch = ";"
+			fallthrough
+		case ":#":
+			s.closeComment()
+			s.openComment("")
+			s.copen = tok
+			return []Token{s.switchDelim(tok, ch + "/")}
+		case "/#":
+			if !s.bc {
+				s.openComment(tok.Data)
+				s.copen = tok
+				return nil
 			}
-		} else if bc {
-			continue
+		case "#;":
+			ch = ";"
+			fallthrough
+		case "#:":
+			s.closeComment()
+			return []Token{s.switchDelim(tok, "/" + ch)}
+		case "#/":
+			if s.bc {
+				s.skip(tok)
+				s.closeComment()
+				return nil
+			}
+			if s.trivia {
+				s.addTrivia(Trivia{BLOCKCOMMENT, tok.Data})
+			}
+			return nil
 		}
+	}
 
-		out = append(out, tok)
+	if s.bc {
+		s.skip(tok)
+		return nil
 	}
 
+	return []Token{tok}
+}
+
+// Make the block delimiter a comment switching delimiter stands for
+func (s *Scanner) switchDelim(tok Token, data string) Token {
+	tok.Raw = tok.Data
+	tok.Data = data
+	return tok
+}
+
+// Add a token skipped inside a block comment to the comment's text
+func (s *Scanner) skip(tok Token) {
+	if !s.trivia {
+		return
+	}
+
+	s.takePending()
+	s.comment.WriteString(tok.Source())
+}
+
+// Start a block comment
+func (s *Scanner) openComment(start string) {
+	s.bc = true
+	s.cstart = len(s.pending)
+	s.comment.Reset()
+	s.comment.WriteString(start)
+}
+
+// Move the trivia read since the block comment started into the comment's text
+func (s *Scanner) takePending() {
+	for _, t := range s.pending[s.cstart:] {
+		s.comment.WriteString(t.Data)
+	}
+	s.pending = s.pending[:s.cstart]
+}
+
+// End a block comment, turning its text into trivia
+func (s *Scanner) closeComment() {
+	if s.bc && s.trivia {
+		s.takePending()
+
+		if s.comment.Len() > 0 {
+			s.pending = append(s.pending, Trivia{BLOCKCOMMENT, s.comment.String()})
+		}
+	}
+
+	s.comment.Reset()
+	s.bc = false
+	s.cstart = 0
+}
+
+// Remove block comments
+func stripBlockComments(t []Token) []Token {
+	s := Scanner{}
+	out := []Token{}
+	for _, tok := range t {
+		out = append(out, s.filter(tok)...)
+	}
 	return out
 }
 
@@ -210,140 +650,51 @@ func endsPre(toks *[]Token) bool {
 	return o
 }
 
-// TokenizeFile tries to read a file and turn it into a series of tokens
-func TokenizeFile(path string) []Token {
-	out := []Token{}
-
-	fd, err := os.Open(path)
-
-	if err != nil {
-		return out
-	}
-
-	read := bufio.NewReader(fd)
-
-	b := strings.Builder{}
-
-	max := maxResRunes()
-
-	ln, cn, last := int(1), int(-1), int(0)
-	sp, pre := false, false
-
-	for r := rune(' '); ; r, _, err = read.ReadRune() {
-		cn++
-		// If error in stream or EOF, break
-		if err != nil {
-			if err != io.EOF {
-				out = append(out, Token{Type: -1})
-			} else if b.String() != "" {
-				out = append(out, Token{Type: checkToken(b.String(), pre), Data: b.String(), Line: ln, Char: last})
-			}
-			break
-		}
-
-		// Checking for a space
-		if unicode.IsSpace(r) {
-			sp = true
-			if b.String() != "" {
-				out = append(out, Token{Type: checkToken(b.String(), pre), Data: b.String(), Line: ln, Char: last})
-				b.Reset()
-			}
-
-			// checking for a newline
-			if r == '\n' {
-				ln++
-				cn = -1
-				last = 0
-			}
-
-			continue
-		} else if sp {
-			last = cn
-			sp = false
-		}
-
-		if unicode.IsNumber(r) && b.String() == "" {
-			read.UnreadRune()
-			out = append(out, numericLiteral(read, ln, &cn))
-			sp = true
-
-			continue
-		}
-
-		if r == '\'' {
-			if b.String() != "" {
-				out = append(out, Token{Type: checkToken(b.String(), pre), Data: b.String(), Line: ln, Char: last})
-				b.Reset()
-			}
+// Tokenize reads all the tokens from r.
+// Malformed tokens are returned as diagnostics, the error is set if r could not be read.
+func Tokenize(r io.Reader, filename string) ([]Token, []Diagnostic, error) {
+	return scanAll(NewScanner(r, filename))
+}
 
-			read.UnreadRune()
-			out = append(out, charLiteral(read, ln, &cn))
-			sp = true
+// TokenizeTrivia is like Tokenize, but keeps whitespace and comments on the tokens.
+// Joining the Source of every token gives back the original text.
+func TokenizeTrivia(r io.Reader, filename string) ([]Token, []Diagnostic, error) {
+	s := NewScanner(r, filename)
+	s.KeepTrivia()
+	return scanAll(s)
+}
 
-			continue
+// Read every token from a scanner
+func scanAll(s *Scanner) ([]Token, []Diagnostic, error) {
+	out, diags := []Token{}, []Diagnostic{}
+
+	for {
+		t, err := s.Next()
+		if err == io.EOF {
+			return out, diags, nil
+		} else if d, ok := err.(Diagnostic); ok {
+			diags = append(diags, d)
+		} else if err != nil {
+			return out, diags, err
 		}
 
-		if r == '"' {
-			if b.String() != "" {
-				out = append(out, Token{Type: checkToken(b.String(), pre), Data: b.String()})
-				b.Reset()
-			}
-
-			read.UnreadRune()
-			out = append(out, stringLiteral(read, &ln, &cn))
-			sp = true
-
+		// An empty EOF token only carries a problem
+		if t.Type == -1 && len(t.Leading) == 0 && len(t.Trailing) == 0 {
 			continue
 		}
+		out = append(out, t)
+	}
+}
 
-		// Checking for a rune group
-		if checkResRune(r) != -1 {
-			if b.String() != "" {
-				out = append(out, Token{Type: checkToken(b.String(), pre), Data: b.String(), Line: ln, Char: last})
-				b.Reset()
-			}
-			last = cn
-			for ; err == nil; r, _, err = read.ReadRune() {
-				if checkResRune(r) == -1 {
-					break
-				}
-				cn++
-				b.WriteRune(r)
-			}
-			cn--
-
-			read.UnreadRune()
-
-			rgs := splitResRunes(b.String(), max, ln, last)
-
-			// Line Comments
-			for i, rg := range rgs {
-				if rg.Data == "#" {
-					rgs = rgs[:i]
-					read.ReadString('\n')
-					ln++
-					cn = -1
-					last = 0
-					break
-				}
-			}
-
-			out = append(out, rgs...)
-
-			b.Reset()
-
-			sp = true
-
-			if endsDef(&rgs) {
-				pre = endsPre(&rgs)
-			}
-
-			continue
-		}
+// TokenizeFile tries to read a file and turn it into a series of tokens
+func TokenizeFile(path string) ([]Token, []Diagnostic, error) {
+	fd, err := os.Open(path)
 
-		// Accumulate
-		b.WriteRune(r)
+	if err != nil {
+		return []Token{}, nil, err
 	}
 
-	return stripBlockComments(out)
+	defer fd.Close()
+
+	return Tokenize(fd, path)
 }
@@ -46,13 +46,12 @@ func parseValueList(tokens *[]Token, tok, max int) (Node, int) {
 			case "}", ")", "]", "/;", ";/":
 				return out, tok
 			default:
-				mx := findClosing(tokens, tok)
-				if mx > -1 {
-					tmp, tok = parseValue(tokens, tok, mx + 1)
-					out.Sub = append(out.Sub, tmp)
-				} else {
+				// A value starting with a delimiter may still go on after it, as in (a + b) * c
+				if findClosing(tokens, tok) < 0 {
 					errOut("Failed to find closing delim within list of values", t)
 				}
+				tmp, tok = parseValue(tokens, tok, max)
+				out.Sub = append(out.Sub, tmp)
 			}
 		case INLNSEP:
 			tok++
@@ -159,3 +158,16 @@ func parseStatementList(tokens *[]Token, tok, max int) (Node, int) {
 
 	return out, tok
 }
+
+// Parse the values of a case block (case (1, 2)).  Each value is kept as a value statement
+// so the list looks like the statement lists of other control flow blocks.
+func parseCaseList(tokens *[]Token, tok, max int) (Node, int) {
+	vl, tok := parseValueList(tokens, tok, max)
+	out := Node{Data: Token{Type: 10, Data: "slist"}}
+
+	for _, v := range vl.Sub {
+		out.Sub = append(out.Sub, Node{Data: Token{Type: 10, Data: "value"}, Sub: []Node{v}})
+	}
+
+	return out, tok
+}
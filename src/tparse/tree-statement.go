@@ -17,19 +17,19 @@
 package tparse
 
 // TODO: re-validate this code.  I forgot if it works or not.
-func parseBlock(tokens *[]Token, tok, max int) (Node, int) {
-	out, tmp, def, name, sparse := Node{}, Node{}, Node{}, false, false
-	out.Data = Token{Type: 10, Data: "block"}
+func parseBlockDef(tokens *[]Token, tok, max int) (Node, int) {
+	tmp, def, name, sparse, cases := Node{}, Node{}, false, false, false
 	def.Data = Token{Type: 10, Data: "bdef"}
 
-
 	for ;tok < max; tok++{
 		t := (*tokens)[tok]
 		tmp = Node{}
 		switch t.Type {
 		case DELIMIT:
 			if t.Data == "(" {
-				if sparse {
+				if cases {
+					tmp, tok = parseCaseList(tokens, tok + 1, max)
+				} else if sparse {
 					tmp, tok = parseStatementList(tokens, tok + 1, max)
 				} else {
 					tmp, tok = parseParamList(tokens, tok + 1, max)
@@ -45,7 +45,7 @@ func parseBlock(tokens *[]Token, tok, max int) (Node, int) {
 				tmp.Data.Data = "[]"
 				def.Sub = append(def.Sub, tmp)
 			} else {
-				goto BREAK
+				return def, tok
 			}
 		case DEFWORD:
 			if name {
@@ -75,67 +75,87 @@ func parseBlock(tokens *[]Token, tok, max int) (Node, int) {
 				}
 				fallthrough
 			case "if", "match", "case", "loop":
+				cases = t.Data == "case"
 				name = true
 				sparse = true
 				fallthrough
+			case "default":
+				name = true
+				fallthrough
 			case "export", "inline", "raw", "override":
 				tmp.Data = t
 				def.Sub = append(def.Sub, tmp)
-			case "module", "method":
+			case "module", "method", "interface":
 				if (*tokens)[tok+1].Type != DEFWORD && !name {
-					errOut("You must provide a name for a module or method.", t)
+					errOut("You must provide a name for a module, method, or interface.", t)
 				} else if !name {
-					tmp.Sub = append(tmp.Sub, Node{(*tokens)[tok+1], []Node{}})
+					tmp.Sub = append(tmp.Sub, Node{Data: (*tokens)[tok+1], Sub: []Node{}})
 					tok++
 				}
 				tmp.Data = t
 				def.Sub = append(def.Sub, tmp)
 				tok++
-				goto BREAK
+				return def, tok
 			default:
 				errOut("Unexpected keyword in block definition.", t)
 			}
 		case LINESEP:
-			goto BREAK
+			return def, tok
 		}
 	}
 
-	BREAK:
+	return def, tok
+}
 
-	out.Sub = append(out.Sub, def)
+func parseBlock(tokens *[]Token, tok, max int) (Node, int) {
+	out, tmp := Node{}, Node{}
+	out.Data = Token{Type: 10, Data: "block"}
+
+	// The block spans from its opening delimiter to its closing one
+	if tok > 0 {
+		open := (*tokens)[tok - 1]
+		out.Data.Line, out.Data.Char, out.Data.Offset, out.Data.End = open.Line, open.Char, open.Offset, open.End
+	}
+
+	// A broken definition still lets us parse the block's contents
+	tmp, tok = tryParse(parseBlockDef, tokens, tok, tok, max)
+	out.Sub = append(out.Sub, tmp)
 
 	for ;tok < max; {
 		t := (*tokens)[tok]
 
 		switch t.Data {
 		case ";/", ";;", ";:":
+			out.Data.End = t.End
 			return out, tok
 		case ";":
 
-			tmp, tok = parseStatement(tokens, tok + 1, max)
+			tmp, tok = tryParse(parseStatement, tokens, tok, tok + 1, max)
 		case "/;", ":;":
 			REBLOCK:
 			
-			tmp, tok = parseBlock(tokens, tok + 1, max)
+			tmp, tok = tryParse(parseBlock, tokens, tok, tok + 1, max)
 			
-			if (*tokens)[tok].Data == ";;" {
+			if tok < max && (*tokens)[tok].Data == ";;" {
 				out.Sub = append(out.Sub, tmp)
 				goto REBLOCK
-			} else if (*tokens)[tok].Data == ";/" {
+			} else if tok < max && (*tokens)[tok].Data == ";/" {
 				tok++
 			}
 
 		case "/:":
-			tmp, tok = parsePreBlock(tokens, tok + 1, max)
+			tmp, tok = tryParse(parsePreBlock, tokens, tok, tok + 1, max)
 		case ":":
-			tmp, tok = parsePre(tokens, tok + 1, max)
+			tmp, tok = tryParse(parsePre, tokens, tok, tok + 1, max)
 		default:
-			errOut("Error: unexpected token when parsing a code block", t)
+			tmp, tok = skipError(tokens, tok, max, newDiag("Error: unexpected token when parsing a code block", t))
 		}
 
 		out.Sub = append(out.Sub, tmp)
 	}
 
+	out.Sub = append(out.Sub, errNode(newDiag("Unexpected end of file, block was never closed.", (*tokens)[max - 1]), []Token{}))
+
 	return out, tok
 }
 
@@ -204,11 +224,28 @@ func keywordStatement(tokens *[]Token, tok, max int) (Node, int) {
 			tok++
 		}
 
+		// The struct this one extends, kept as the type under the extends keyword
+		if (*tokens)[tok].Data == "extends" {
+			ext := Node{Data: (*tokens)[tok]}
+			tmp, tok = parseType(tokens, tok + 1, max, false)
+			if len(tmp.Sub) == 0 {
+				errOut("Expected a struct type after extends", ext.Data)
+			}
+			ext.Sub = append(ext.Sub, tmp)
+			out.Sub = append(out.Sub, ext)
+		}
+
 		if (*tokens)[tok].Data != "{" {
 			errOut("Could not find struct member list", (*tokens)[tok])
 		}
 
-		tmp, tok = parseParamList(tokens, tok + 1, max)
+		// A struct which extends another may not add any members
+		if tok + 1 < max && (*tokens)[tok + 1].Data == "}" {
+			tmp = Node{Data: Token{Type: 10, Data: "plist"}}
+			tok++
+		} else {
+			tmp, tok = parseParamList(tokens, tok + 1, max)
+		}
 		tok++
 	case "enum":
 		if (*tokens)[tok].Type != DEFWORD {
@@ -263,7 +300,7 @@ func keywordStatement(tokens *[]Token, tok, max int) (Node, int) {
 
 // Should work, but none of this is tested.
 func parseDef(tokens *[]Token, tok, max int) (Node, int) {
-	out := Node{Data: Token{10, "define", 0, 0}}
+	out := Node{Data: Token{Type: 10, Data: "define"}}
 	var tmp Node
 
 	tmp, tok = parseType(tokens, tok, max, false)
@@ -16,42 +16,46 @@
 
 package tparse
 
-// Ops order in TNSL
-// Cast/Paren > Address > Get > Inc/Dec > Math > Bitwise > Logic
+// Ops order in TNSL (lower numbers bind tighter)
+// Cast/Paren > Address > Get > Inc/Dec > Math > Bitwise > Logic > Assignment
 
+// UNARY_PRE gives the prefix operators and the loosest binary operators they take in.
+// -a.b is -(a.b) and !a & b is !(a & b), but ~a.b is (~a).b.
 var UNARY_PRE = map[string]int {
 	"~": 0,
 	"++": 2,
 	"--": 2,
 	"!": 6,
-	"len": 0,
-	"-": 0,
+	"len": 1,
+	"-": 1,
 }
 
+// UNARY_POST gives the postfix operators.  They apply to the value just before them.
 var UNARY_POST = map[string]int {
 	"`": 0,
 	"++": 2,
 	"--": 2,
 }
 
+// ORDER gives the binary operators and how tightly they bind
 var ORDER = map[string]int{
 	// Get
 	".": 1,
 
+	// Type check (the right side is a type)
 	"is": 2,
 
 	// Multiplication
 	"*": 3,
 	// Division
 	"/": 3,
+	// Mod
+	"%": 3,
 
 	// Addition
 	"+": 4,
 	// Subtraction
 	"-": 4,
-	
-	// Mod
-	"%": 5,
 
 	// Bitwise and
 	"&": 6,
@@ -93,66 +97,120 @@ var ORDER = map[string]int{
 
 	// Assignement
 	"=": 9,
+
+	// Compound assignment (a += b is a = a + b)
+	"+=": 9,
+	"-=": 9,
+	"*=": 9,
+	"/=": 9,
+	"%=": 9,
+	"&=": 9,
+	"|=": 9,
+	"^=": 9,
+	// a ~= b is a = ~b, a `= b is a` = b
+	"~=": 9,
+	"`=": 9,
+}
+
+// RIGHT_ASSOC lists the binary operators which group from the right (a = b = c is a = (b = c)).
+// All others group from the left (a - b - c is (a - b) - c).
+// Get chains are kept right nested (a.(b.c)) since that is how the evaluator walks them.
+var RIGHT_ASSOC = map[string]bool{
+	".":  true,
+	"=":  true,
+	"+=": true,
+	"-=": true,
+	"*=": true,
+	"/=": true,
+	"%=": true,
+	"&=": true,
+	"|=": true,
+	"^=": true,
+	"~=": true,
+	"`=": true,
+}
+
+func maxOrder() int {
+	max := 0
+	for _, v := range ORDER {
+		if v > max {
+			max = v
+		}
+	}
+	return max
+}
+
+// Parse a value along with its prefix operators
+func parseUnaryOps(tokens *[]Token, tok, max int) (Node, int) {
+	if tok >= max {
+		errOut("Expected to find value, but there wasn't one", (*tokens)[max - 1])
+	}
+
+	t := (*tokens)[tok]
+	if t.Type != AUGMENT {
+		return parsePostfixOps(tokens, tok, max)
+	}
+
+	level, prs := UNARY_PRE[t.Data]
+	if !prs {
+		errOut("Unexpected operator when parsing value", t)
+	}
+
+	val, tok := parseOrder(tokens, tok + 1, max, level)
+	if val.Data.Type == 10 && val.Data.Data == "comp" {
+		errOut("Composite values may not use unary operators.", t)
+	}
+
+	return Node{Data: t, Sub: []Node{val}}, tok
 }
 
-// Works? Please test. 
-func parseUnaryOps(tokens *[]Token, tok, max int) (Node) {
+// Parse a single value (word, literal, composite, or parenthetical) and any postfix operators after it.
+// Postfix operators are added as sub-nodes of the value.
+func parsePostfixOps(tokens *[]Token, tok, max int) (Node, int) {
 	var out Node
-	var vnode *Node = &out
-	val, comp := false, false
-	// Pre-value op scan
-	for ; tok < max && !val; tok++ {
-		t := (*tokens)[tok]
-		switch t.Type {
-		case DELIMIT:
-			
-			switch t.Data {
-			case "{": // Array or struct evaluation, parenthetical value
-				if vnode != &out {
-					errOut("Composite values may not use unary operators.", out.Data)
-				}
-				(*vnode), tok = parseValueList(tokens, tok + 1, max)
-				(*vnode).Data.Data = "comp"
-				val = true
-				comp = true
-			case "(": //Typecast or paren statement
-				mx := findClosing(tokens, tok)
-				(*vnode) = parseBinaryOp(tokens, tok + 1, mx)
-				tok = mx
-				val = true
-			default:
-				errOut("Unexpected delimiter when parsing value", t)
+	comp := false
+
+	t := (*tokens)[tok]
+	switch t.Type {
+	case DELIMIT:
+		switch t.Data {
+		case "{": // Array or struct evaluation
+			mx := findClosing(tokens, tok)
+			if mx < 0 || mx >= max {
+				errOut("Unable to find closing brace when parsing a value", t)
 			}
-		case LITERAL, DEFWORD:
-			(*vnode).Data = t
-			val = true
-		case AUGMENT:
-			_, prs := UNARY_PRE[t.Data]
-			if !prs {
-				errOut("Parser bug!  Operator failed to load into AST.", t)
-			} else {
-				(*vnode) = Node{t, []Node{Node{}}}
-				vnode = &((*vnode).Sub[0])
+			out, _ = parseValueList(tokens, tok + 1, mx)
+			out.Data.Data = "comp"
+			tok = mx + 1
+			comp = true
+		case "(": // Paren statement
+			mx := findClosing(tokens, tok)
+			if mx < 0 || mx >= max {
+				errOut("Unable to find closing paren when parsing a value", t)
 			}
+			out = parseBinaryOp(tokens, tok + 1, mx)
+			tok = mx + 1
 		default:
-			errOut("Unexpected token in value declaration", t)
+			errOut("Unexpected delimiter when parsing value", t)
 		}
+	case LITERAL, DEFWORD:
+		out.Data = t
+		tok++
+	default:
+		errOut("Unexpected token in value declaration", t)
 	}
 
-	// Sanity check: make sure there's actually a value here
-	if !val {
-		errOut("Expected to find value, but there wasn't one", (*tokens)[max - 1])
-	}
-
-	// Post-value op scan
 	for ; tok < max; tok++ {
 		t := (*tokens)[tok]
 		var tmp Node
-		switch t.Type {
-		case DELIMIT:
-			mx := findClosing(tokens, tok)
 
-			if mx < 0 {
+		if t.Type == DELIMIT {
+			if t.Data != "(" && t.Data != "[" && t.Data != "{" {
+				break
+			}
+
+			mx := findClosing(tokens, tok)
+			if mx < 0 || mx >= max {
 				errOut("Unable to find closing delim when parsing a value", t)
 			}
 
@@ -172,90 +230,57 @@ func parseUnaryOps(tokens *[]Token, tok, max int) (Node) {
 				}
 				tmp, tok = parseValueList(tokens, tok + 1, mx + 1)
 				tmp.Data.Data = "index"
-			default:
-				errOut("Unexpected delimiter when parsing value", t)
 			}
-		case AUGMENT:
-			_, prs := UNARY_POST[t.Data]
-			if !prs {
-				errOut("Parser bug!  Operator failed to load into AST.", t)
-			}else if comp {
+		} else if _, prs := UNARY_POST[t.Data]; prs && t.Type == AUGMENT {
+			if comp {
 				errOut("Composite values are not allowed to use unary operators.", t)
 			}
-			tmp = Node{}
 			tmp.Data = t
-		default:
-			errOut("Unexpected token in value declaration", t)
+		} else {
+			break
 		}
-		(*vnode).Sub = append((*vnode).Sub, tmp)
+
+		out.Sub = append(out.Sub, tmp)
 	}
 
-	return out
+	return out, tok
 }
 
-// Works? Please test.
-func parseBinaryOp(tokens *[]Token, tok, max int) (Node) {
-	out := Node{}
-	first := tok
-	var high, highOrder, bincount int = first, 0, 0
-	var curl, brak, parn int = 0, 0, 0
+// Parse a value and the binary operators after it which bind at least as tightly as level (precedence climbing)
+func parseOrder(tokens *[]Token, tok, max, level int) (Node, int) {
+	out, tok := parseUnaryOps(tokens, tok, max)
 
-	// Find first high-order op
-	for ; tok < max; tok++ {
+	for tok < max {
 		t := (*tokens)[tok]
-		if t.Type == DELIMIT {
-			switch t.Data {
-			case "{":
-				curl++
-			case "[":
-				brak++
-			case "(":
-				parn++
-			
-			case "}":
-				curl--
-			case "]":
-				brak--
-			case ")":
-				parn--
-			}
+		order, prs := ORDER[t.Data]
+		if t.Type != AUGMENT || !prs || order > level {
+			break
+		}
 
-			if curl < 0 || brak < 0 || parn < 0 {
-				if curl > 0 || brak > 0 || parn > 0 || tok < max - 1 {
-					errOut("Un-matched closing delimiter when parsing a type.", t)
-				}
-			}
-		} else if t.Type == AUGMENT {
-			order, prs := ORDER[t.Data]
-			if prs == false || curl > 0 || brak > 0 || parn > 0 {
-				continue
+		var rhs Node
+		if t.Data == "is" {
+			rhs, tok = parseType(tokens, tok + 1, max, false)
+			if len(rhs.Sub) == 0 {
+				errOut("Expected a type after 'is'", t)
 			}
-
-			if t.Data == "-" {
-				_, prs := ORDER[(*tokens)[tok - 1].Data]
-				if prs || (*tokens)[tok - 1].Data == "return" {
-					continue
-				} else if order > highOrder {
-					high, highOrder = tok, order
-				}
-			}  else if order > highOrder {
-				high, highOrder = tok, order
-			}
-			// TODO: Add in case for the "is" operator
-			bincount++
+		} else if RIGHT_ASSOC[t.Data] {
+			rhs, tok = parseOrder(tokens, tok + 1, max, order)
+		} else {
+			rhs, tok = parseOrder(tokens, tok + 1, max, order - 1)
 		}
+
+		out = Node{Data: t, Sub: []Node{out, rhs}}
 	}
 
-	out.Data = (*tokens)[high]
+	return out, tok
+}
+
+// Parse all the tokens from tok to max as one value
+func parseBinaryOp(tokens *[]Token, tok, max int) (Node) {
+	out, tok := parseOrder(tokens, tok, max, maxOrder())
 
-	if bincount == 0 {
-		// No binops means we have a pure value to parse.  Parse all unary ops around it.
-		return parseUnaryOps(tokens, first, max)
-	} else {
-		
-		// Recursive split to lower order operations
-		out.Sub = append(out.Sub, parseBinaryOp(tokens, first, high))
-		out.Sub = append(out.Sub, parseBinaryOp(tokens, high + 1, max))
+	if tok < max {
+		errOut("Unexpected token after value", (*tokens)[tok])
 	}
 
 	return out
@@ -408,7 +433,7 @@ func parseType(tokens *[]Token, tok, max int, param bool) (Node, int) {
 			out.Sub = append(out.Sub, tmp)
 			
 			if param && (*tokens)[tok].Data == "`" {
-				tmp = Node{(*tokens)[tok], []Node{}}
+				tmp = Node{Data: (*tokens)[tok], Sub: []Node{}}
 				out.Sub = append(out.Sub, tmp)
 				tok++
 			}
@@ -430,7 +455,7 @@ func parseType(tokens *[]Token, tok, max int, param bool) (Node, int) {
 			out.Sub = append(out.Sub, tmp)
 
 			if param && (*tokens)[tok].Data == "`" {
-				tmp = Node{(*tokens)[tok], []Node{}}
+				tmp = Node{Data: (*tokens)[tok], Sub: []Node{}}
 				out.Sub = append(out.Sub, tmp)
 				tok++
 			}
@@ -451,7 +476,7 @@ func parseType(tokens *[]Token, tok, max int, param bool) (Node, int) {
 			if tok < max-1 {
 				if t.Data == "{" {
 					// What happens when an array type is defined
-					tmp.Data = Token{AUGMENT, "{}", t.Line, t.Char}
+					tmp.Data = Token{Type: AUGMENT, Data: "{}", Line: t.Line, Char: t.Char, Offset: t.Offset, End: t.End}
 					if (*tokens)[tok+1].Data == "}" {
 						// Length variable array, add no sub-nodes and increment
 						tok++
@@ -461,6 +486,10 @@ func parseType(tokens *[]Token, tok, max int, param bool) (Node, int) {
 						tmp2, tok = parseValueList(tokens, tok + 1, max)
 						tmp.Sub = append(tmp.Sub, tmp2)
 					}
+
+					if tok < max {
+						tmp.Data.End = (*tokens)[tok].End
+					}
 				} else if t.Data == ")" || t.Data == "]" || t.Data == "}"{
 					// End of type
 					goto TYPEDONE
@@ -16,28 +16,200 @@
 
 package tparse
 
-import "fmt"
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+)
 
 // ID 9 = ast root
 // ID 10 = ast token
 
+// ERRNODE is the type of a node which stands in for code that failed to parse.
+// The node's data is the error message, the first sub-node is the token which caused
+// the error and the rest are the tokens that were skipped to recover from it.
+const ERRNODE = 12
+
+// Diagnostic represents a problem found when reading or parsing a file
+type Diagnostic struct {
+	Message string
+	File    string
+	// Line of the problem, starting at 1
+	Line    int
+	// Column of the problem, starting at 1
+	Col     int
+	// The token that caused the problem
+	Token   Token
+	// The problem does not stop the file from being used
+	Warning bool
+}
+
+// Error formats the diagnostic as file:line:col: message (or file:line:col: warning: message)
+func (d Diagnostic) Error() string {
+	if d.Warning {
+		return fmt.Sprintf("%s:%d:%d: warning: %s", d.File, d.Line, d.Col, d.Message)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", d.File, d.Line, d.Col, d.Message)
+}
+
+// HasErrors tells if any of the diagnostics are errors rather than warnings
+func HasErrors(diags []Diagnostic) bool {
+	for _, d := range diags {
+		if !d.Warning {
+			return true
+		}
+	}
+	return false
+}
+
+func newDiag(message string, token Token) Diagnostic {
+	return Diagnostic{Message: message, Line: token.Line, Col: token.Char + 1, Token: token}
+}
+
 func errOut(message string, token Token) {
-	fmt.Println(message)
-	fmt.Println(token)
-	panic("AST Error")
+	panic(newDiag(message, token))
 }
 
 func errOutV(message string, tok, max int, token Token) {
-	fmt.Println(message)
-	fmt.Println(token)
-	fmt.Println(tok)
-	fmt.Println(max)
-	panic("AST Error")
+	errOut(fmt.Sprintf("%s (token %d of %d)", message, tok, max), token)
+}
+
+// Turn a recovered parser panic into a diagnostic.  Parser bugs (such as
+// running off the end of the token list) are reported against the given token.
+func recoverDiag(r interface{}, file string, at Token) Diagnostic {
+	var d Diagnostic
+	switch e := r.(type) {
+	case Diagnostic:
+		d = e
+	case runtime.Error:
+		d = newDiag("[Internal] Parser failure: " + e.Error(), at)
+	default:
+		panic(r)
+	}
+	d.File = file
+	return d
+}
+
+// Make an error node from a diagnostic and the tokens skipped because of it
+func errNode(d Diagnostic, skipped []Token) Node {
+	out := Node{Data: Token{Type: ERRNODE, Data: d.Message, Line: d.Token.Line, Char: d.Token.Char, Offset: d.Token.Offset, End: d.Token.End}}
+	out.Sub = append(out.Sub, Node{Data: d.Token})
+	for _, t := range skipped {
+		out.Sub = append(out.Sub, Node{Data: t})
+	}
+	return out
+}
+
+// Is a token one the parser can pick back up at after an error
+func isResync(t Token) bool {
+	switch t.Data {
+	case ";", "/;", ";/", ";;", ":", "/:", ":;", ";:":
+		return true
+	}
+	return false
+}
+
+// Make an error node for d, skipping from start up to the next statement, block,
+// or pre-processor marker at or after the token which caused the error
+func skipError(tokens *[]Token, start, max int, d Diagnostic) (Node, int) {
+	tok := start + 1
+	for ; tok < max; tok++ {
+		t := (*tokens)[tok]
+		if !isResync(t) {
+			continue
+		}
+		if d.Token.Line == 0 || t.Line > d.Token.Line || (t.Line == d.Token.Line && t.Char >= d.Token.Char) {
+			break
+		}
+	}
+
+	return errNode(d, (*tokens)[start:tok]), tok
+}
+
+// Run a parse function from tok, and if it fails, recover by skipping ahead from start (see skipError)
+func tryParse(parse func(*[]Token, int, int) (Node, int), tokens *[]Token, start, tok, max int) (out Node, next int) {
+	defer func() {
+		if r := recover(); r != nil {
+			at := (*tokens)[start]
+			if tok < max {
+				at = (*tokens)[tok]
+			}
+			out, next = skipError(tokens, start, max, recoverDiag(r, "", at))
+		}
+	}()
+
+	return parse(tokens, tok, max)
 }
-// MakeTree creates an AST out of a set of tokens
+
+// Gather the errors stored in a tree
+func collectErrors(n Node, file string, diags *[]Diagnostic) {
+	Inspect(&n, func(e *Node) bool {
+		if e == nil || e.Data.Type != ERRNODE {
+			return true
+		}
+
+		d := newDiag(e.Data.Data, e.Sub[0].Data)
+		d.File = file
+		*diags = append(*diags, d)
+		return false
+	})
+}
+
+// Report signed literals one past their type's largest value (like 128i8) which
+// are not negated, as only their negative fits
+func checkLimits(n Node, file string, diags *[]Diagnostic) {
+	Apply(&n, func(c *Cursor) bool {
+		e, p := c.Node(), c.Parent()
+		if e.Data.Type != LITERAL || e.Data.Kind != INTLIT {
+			return true
+		}
+		if p != nil && p.Data.Type == AUGMENT && p.Data.Data == "-" && len(p.Sub) == 1 {
+			return true
+		}
+
+		if typ := limitType(e.Data.Data); typ != "" {
+			d := newDiag(fmt.Sprintf("Invalid numeric literal %s: out of range for %s (only -%s fits)", e.Data.Data, typ, e.Data.Data), e.Data)
+			d.File = file
+			*diags = append(*diags, d)
+		}
+		return true
+	}, nil)
+}
+
+// Parse reads a file from r and creates an AST out of it.
+// Syntax errors are returned as diagnostics, the error is only set if r could not be read.
+// Even if there are syntax errors, the partial tree is returned.
+func Parse(r io.Reader, filename string) (Node, []Diagnostic, error) {
+	tokens, diags, err := Tokenize(r, filename)
+	if err != nil {
+		return Node{}, diags, err
+	}
+
+	out, pdiags := ParseTokens(&tokens, filename)
+	diags = append(diags, pdiags...)
+
+	sort.SliceStable(diags, func(i, j int) bool {
+		return diags[i].Line < diags[j].Line || (diags[i].Line == diags[j].Line && diags[i].Col < diags[j].Col)
+	})
+
+	return out, diags, nil
+}
+
+// ParseTokens creates an AST out of a set of tokens, returning any syntax errors as diagnostics
+func ParseTokens(tokens *[]Token, file string) (Node, []Diagnostic) {
+	diags := []Diagnostic{}
+	out := MakeTree(tokens, file)
+	collectErrors(out, file, &diags)
+	checkLimits(out, file, &diags)
+	return out, diags
+}
+
+// MakeTree creates an AST out of a set of tokens.
+// Any code which fails to parse is replaced with an ERRNODE and parsing continues after it.
 func MakeTree(tokens *[]Token, file string) Node {
 	out := Node{}
-	out.Data = Token{9, file, 0, 0}
+	out.Data = Token{Type: 9, Data: file}
 
 	tmp := Node{}
 
@@ -49,30 +221,61 @@ func MakeTree(tokens *[]Token, file string) Node {
 		case "/;", ";;", ":;":
 			REBLOCK:
 			
-			tmp, tok = parseBlock(tokens, tok + 1, max)
+			tmp, tok = tryParse(parseBlock, tokens, tok, tok + 1, max)
 
-			if (*tokens)[tok].Data == ";;" {
+			if tok < max && (*tokens)[tok].Data == ";;" {
 				out.Sub = append(out.Sub, tmp)
 				goto REBLOCK
-			} else if (*tokens)[tok].Data == ";/" {
+			} else if tok < max && (*tokens)[tok].Data == ";/" {
 				tok++
 			}
 		case ";":
-			tmp, tok = parseStatement(tokens, tok + 1, max)
+			tmp, tok = tryParse(parseStatement, tokens, tok, tok + 1, max)
 		case "/:", ";:":
-			tmp, tok = parsePreBlock(tokens, tok + 1, max)
+			tmp, tok = tryParse(parsePreBlock, tokens, tok, tok + 1, max)
 		case ":":
-			tmp, tok = parsePre(tokens, tok + 1, max)
+			tmp, tok = tryParse(parsePre, tokens, tok, tok + 1, max)
 		default:
-			errOut("Unexpected token in file root", t)
+			tmp, tok = skipError(tokens, tok, max, newDiag("Unexpected token in file root", t))
 		}
 
 		out.Sub = append(out.Sub, tmp)
 	}
 
+	SetSpans(&out)
+
 	return out
 }
 
+// SetSpans works out the Start and End of a node and all its sub-nodes from
+// the positions of their tokens.  Tokens made by the parser (like "block" or "value")
+// have no position, so their nodes only cover their sub-nodes.
+func SetSpans(n *Node) {
+	n.Start, n.End = Pos{}, Pos{}
+	if n.Data.Line > 0 {
+		n.Start, n.End = n.Data.Start(), n.Data.End
+		if n.End.Line == 0 {
+			n.End = n.Start
+		}
+	}
+
+	for i := range n.Sub {
+		SetSpans(&n.Sub[i])
+		c := n.Sub[i]
+		if c.Start.Line == 0 {
+			continue
+		}
+
+		if n.Start.Line == 0 || c.Start.Offset < n.Start.Offset {
+			n.Start = c.Start
+		}
+
+		if n.End.Line == 0 || c.End.Offset > n.End.Offset {
+			n.End = c.End
+		}
+	}
+}
+
 func findClosing(tokens *[]Token, tok int) int {
 	t := (*tokens)[tok]
 	var match string
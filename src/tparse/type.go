@@ -43,6 +43,21 @@ const KEYWORD = 7
 // DEFWORD represents a user-defined word such as a variable, method, or struct
 const DEFWORD = 8
 
+// INTLIT represents an integer literal (kept in Token.Kind)
+const INTLIT = 1
+
+// FLOATLIT represents a floating point literal
+const FLOATLIT = 2
+
+// CHARLIT represents a character literal
+const CHARLIT = 3
+
+// STRLIT represents a string literal
+const STRLIT = 4
+
+// BOOLLIT represents a boolean literal
+const BOOLLIT = 5
+
 // PREWORDS represents all the pre-processor directives
 var PREWORDS = []string{
 	"include",
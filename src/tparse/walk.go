@@ -0,0 +1,153 @@
+/*
+   Copyright 2020 Kyle Gunger
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package tparse
+
+// Visitor is used by Walk.  Visit is called for each node; if it returns a
+// visitor, that visitor is used for the node's sub-nodes and then called with nil.
+type Visitor interface {
+	Visit(n *Node) (w Visitor)
+}
+
+// Walk goes through a tree depth first, calling v.Visit for each node
+func Walk(n *Node, v Visitor) {
+	if v = v.Visit(n); v == nil {
+		return
+	}
+
+	for i := range n.Sub {
+		Walk(&(n.Sub[i]), v)
+	}
+
+	v.Visit(nil)
+}
+
+type inspector func(*Node) bool
+
+func (f inspector) Visit(n *Node) Visitor {
+	if f(n) {
+		return f
+	}
+	return nil
+}
+
+// Inspect goes through a tree depth first, calling f for each node.  If f returns
+// true, the node's sub-nodes are inspected and then f is called with nil.
+func Inspect(n *Node, f func(*Node) bool) {
+	Walk(n, inspector(f))
+}
+
+// Cursor points to a node during a call to Apply
+type Cursor struct {
+	root    *Node
+	parent  *Node
+	index   int
+	deleted bool
+}
+
+// Node gives the current node (nil if it was deleted)
+func (c *Cursor) Node() *Node {
+	if c.parent == nil {
+		return c.root
+	} else if c.deleted {
+		return nil
+	}
+	return &(c.parent.Sub[c.index])
+}
+
+// Parent gives the node the current node is a sub-node of (nil for the root)
+func (c *Cursor) Parent() *Node {
+	return c.parent
+}
+
+// Index gives the position of the current node in its parent's Sub (-1 for the root)
+func (c *Cursor) Index() int {
+	if c.parent == nil {
+		return -1
+	}
+	return c.index
+}
+
+// Replace swaps the current node for n.  The sub-nodes of n are the ones visited next.
+func (c *Cursor) Replace(n Node) {
+	if c.deleted {
+		panic("tparse: Replace called on a deleted node")
+	} else if c.parent == nil {
+		*c.root = n
+	} else {
+		c.parent.Sub[c.index] = n
+	}
+}
+
+// Delete removes the current node from its parent
+func (c *Cursor) Delete() {
+	if c.parent == nil {
+		panic("tparse: the root node can not be deleted")
+	} else if c.deleted {
+		return
+	}
+
+	// Copy into a new slice, the old one may be shared with another tree
+	sub := make([]Node, 0, len(c.parent.Sub) - 1)
+	sub = append(sub, c.parent.Sub[:c.index]...)
+	c.parent.Sub = append(sub, c.parent.Sub[c.index + 1:]...)
+	c.deleted = true
+}
+
+// ApplyFunc is called for each node by Apply
+type ApplyFunc func(*Cursor) bool
+
+type applyStop struct{}
+
+// Apply goes through a tree depth first, changing it in place.  pre is called before
+// a node's sub-nodes are visited and post after (either may be nil).  The functions
+// may replace or delete the current node using the cursor.
+// If pre returns false, the node's sub-nodes and post are skipped.
+// If post returns false, Apply stops.
+func Apply(root *Node, pre, post ApplyFunc) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(applyStop); !ok {
+				panic(r)
+			}
+		}
+	}()
+
+	apply(&Cursor{root: root, index: -1}, pre, post)
+}
+
+func apply(c *Cursor, pre, post ApplyFunc) {
+	if pre != nil && !pre(c) {
+		return
+	}
+
+	n := c.Node()
+	if n == nil {
+		return
+	}
+
+	for i := 0; i < len(n.Sub); {
+		sub := Cursor{parent: n, index: i}
+		apply(&sub, pre, post)
+		if !sub.deleted {
+			i++
+		}
+	}
+
+	if post != nil && !post(c) {
+		panic(applyStop{})
+	}
+}
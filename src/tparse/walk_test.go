@@ -0,0 +1,85 @@
+/*
+   Copyright 2020 Kyle Gunger
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+
+package tparse
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// The names defined in a tree, in order
+func defNames(n *Node) []string {
+	out := []string{}
+	Inspect(n, func(c *Node) bool {
+		if c != nil && c.Data.Type == DEFWORD {
+			out = append(out, c.Data.Data)
+		}
+		return true
+	})
+	return out
+}
+
+// Deleting from a copy of a tree which shares its sub-nodes must leave the original alone
+func TestDeleteCopy(t *testing.T) {
+	orig, diags, err := Parse(strings.NewReader("/; main [int]\n\t;int a = 1\n\t;int b = 2\n\t;int c = 3\n;/\n"), "test.tnsl")
+	if err != nil || HasErrors(diags) {
+		t.Fatalf("parsing: %v %v", err, diags)
+	}
+	want := SExprNode(orig)
+
+	cp := orig
+	cp.Sub = append([]Node{}, orig.Sub...)
+
+	Apply(&cp, func(c *Cursor) bool {
+		if n := c.Node(); n.Data.Data == "define" && reflect.DeepEqual(defNames(n), []string{"b"}) {
+			c.Delete()
+			return false
+		}
+		return true
+	}, nil)
+
+	if got := defNames(&cp); !reflect.DeepEqual(got, []string{"main", "a", "c"}) {
+		t.Errorf("copy defines %v, want [main a c]", got)
+	}
+
+	if got := SExprNode(orig); got != want {
+		t.Errorf("original changed:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// Every sub-node can be deleted, one after another
+func TestDeleteAll(t *testing.T) {
+	n := Node{Data: Token{Data: "root"}, Sub: []Node{{Data: Token{Data: "a"}}, {Data: Token{Data: "b"}}, {Data: Token{Data: "c"}}}}
+	seen := []string{}
+
+	Apply(&n, func(c *Cursor) bool {
+		if c.Parent() != nil {
+			seen = append(seen, c.Node().Data.Data)
+			c.Delete()
+			if c.Node() != nil {
+				t.Errorf("deleted node %s still visible", seen[len(seen) - 1])
+			}
+		}
+		return true
+	}, nil)
+
+	if !reflect.DeepEqual(seen, []string{"a", "b", "c"}) || len(n.Sub) != 0 {
+		t.Errorf("visited %v, left %v", seen, n.Sub)
+	}
+}
@@ -1,6 +1,7 @@
 #!/bin/bash
 
 PARSECMD=../build/parse
+TINTCMD=../build/tint
 PARSEFILE=" "
 
 parse () {
@@ -11,8 +12,90 @@ parse () {
 	fi
 }
 
+# Run a test with the interpreter (passing it any flags in $2) and compare what it prints with $1-test.out
+run () {
+	echo "ATTEMPTING TO RUN $1-test.tnsl"
+	$TINTCMD -quiet $2 -in $1-test.tnsl | diff $1-test.out -
+	if [ $? -eq 0 ]; then
+		echo "SUCCESS!"
+	fi
+}
+
+# Write a test's module as JSON with the parser, run it from the JSON, and compare what it prints with $1-test.out
+json () {
+	echo "ATTEMPTING TO RUN $1-test.tnsl FROM JSON"
+	$PARSECMD -writelevel 2 -format json -in $1-test.tnsl -out $1-test.json && $TINTCMD -quiet -json -in $1-test.json | diff $1-test.out -
+	status=(${PIPESTATUS[@]})
+	if [ ${status[0]} -eq 0 ] && [ ${status[1]} -eq 0 ]; then
+		echo "SUCCESS!"
+	fi
+}
+
+# Run a test which should fail (passing the interpreter any flags in $2), and compare the problems it reports with $1-test.err
+fail () {
+	echo "ATTEMPTING TO RUN $1-test.tnsl (expecting errors)"
+	$TINTCMD -quiet $2 -in $1-test.tnsl 2>&1 >/dev/null | diff $1-test.err -
+	status=(${PIPESTATUS[@]})
+	if [ ${status[0]} -ne 0 ] && [ ${status[1]} -eq 0 ]; then
+		echo "SUCCESS!"
+	fi
+}
+
 parse block "$1"
 parse comment "$1"
 parse literal "$1"
 parse parameter "$1"
 parse statement "$1"
+parse precedence "$1"
+parse assign "$1"
+parse bitwise "$1"
+parse arith "$1"
+parse match "$1"
+parse goto "$1"
+parse heap "$1"
+parse interface "$1"
+parse operator "$1"
+parse extends "$1"
+parse scope "$1"
+parse global "$1"
+parse init "$1"
+parse zero "$1"
+parse cast "$1"
+parse initerr "$1"
+parse matcherr "$1"
+parse casterr "$1"
+parse heapuse "$1"
+parse heapfree "$1"
+parse heapleak "$1"
+parse heaploop "$1"
+
+run precedence
+run assign
+run bitwise
+run arith
+run match
+run goto
+run heap -checkheap
+run interface
+run operator
+run extends
+run scope
+run global
+run init
+run zero
+run cast
+
+json global
+json init
+json zero
+json interface
+
+fail initerr
+fail matcherr
+fail literalerr
+fail commenterr
+fail casterr
+fail heapuse -checkheap
+fail heapfree -checkheap
+fail heapleak -checkheap
+fail heaploop -checkheap